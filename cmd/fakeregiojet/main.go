@@ -0,0 +1,33 @@
+// Command fakeregiojet serves a fake RegioJet API for developing and demoing
+// the watchdog offline. Point the watchdog at it with REGIOJET_API_URL.
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+	"os"
+
+	"github.com/bxxf/regiojet-watchdog/internal/fakeregiojet"
+)
+
+func main() {
+	addr := flag.String("addr", ":7901", "address to listen on")
+	fixturePath := flag.String("fixture", "", "JSON fixture to serve instead of the bundled one")
+	flag.Parse()
+
+	fixture := fakeregiojet.DefaultFixture()
+	if *fixturePath != "" {
+		data, err := os.ReadFile(*fixturePath)
+		if err != nil {
+			log.Fatalln("Failed to read fixture:", err)
+		}
+		fixture, err = fakeregiojet.LoadFixture(data)
+		if err != nil {
+			log.Fatalln("Failed to load fixture:", err)
+		}
+	}
+
+	log.Printf("Fake RegioJet API is running on %s...\n", *addr)
+	log.Fatal(http.ListenAndServe(*addr, fakeregiojet.NewServer(fixture)))
+}
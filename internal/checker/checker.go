@@ -3,126 +3,414 @@ package checker
 import (
 	"context"
 	"errors"
-	"fmt"
 	"log"
 	"strconv"
-	"strings"
+	"sync"
 	"time"
 
 	clientpkg "github.com/bxxf/regiojet-watchdog/internal/client"
+	configpkg "github.com/bxxf/regiojet-watchdog/internal/config"
 	databasepkg "github.com/bxxf/regiojet-watchdog/internal/database"
-	discordpkg "github.com/bxxf/regiojet-watchdog/internal/discord"
 	"github.com/bxxf/regiojet-watchdog/internal/models"
+	notifierpkg "github.com/bxxf/regiojet-watchdog/internal/notifier"
 	segmentationpkg "github.com/bxxf/regiojet-watchdog/internal/segmentation"
 	"go.uber.org/fx"
 )
 
+// pollInterval is how often the store is asked for due watchdogs.
+const pollInterval = 5 * time.Second
+
+var errLeaseLost = errors.New("lease of the watchdog was lost")
+
+// Checker checks the watchdogs that are due with a pool of workers and
+// schedules their next check. A watchdog whose previous check is still
+// running is skipped.
+//
+// Several instances can share the store. Each check holds a lease on the
+// watchdog that is renewed while the check runs, so a watchdog is only
+// checked by one instance at a time, and is checked again by any instance
+// once the lease of a crashed one has expired.
+//
+// While the circuit breaker of the train client is open, no watchdogs are
+// checked. Stopping the checker cancels the checks that are running.
 type Checker struct {
-	discordService      *discordpkg.DiscordService
+	renotifyInterval    time.Duration
+	pollCurve           []configpkg.PollStep
+	checkInterval       time.Duration
+	workers             int
+	checkTimeout        time.Duration
+	instanceID          string
+	leaseTTL            time.Duration
+	notificationService *notifierpkg.NotificationService
 	trainClient         *clientpkg.TrainClient
-	database            *databasepkg.DatabaseClient
+	store               databasepkg.WatchdogStore
 	segmentationService *segmentationpkg.SegmentationService
+
+	mu       sync.Mutex
+	inFlight map[string]bool
+	paused   bool
+	ctx      context.Context
+	cancel   context.CancelFunc
+	stop     chan struct{}
+	wg       sync.WaitGroup
 }
 
-func NewChecker(database *databasepkg.DatabaseClient, segmentationService *segmentationpkg.SegmentationService, client *clientpkg.TrainClient, discordService *discordpkg.DiscordService) *Checker {
+func NewChecker(config configpkg.Config, store databasepkg.WatchdogStore, segmentationService *segmentationpkg.SegmentationService, client *clientpkg.TrainClient, notificationService *notifierpkg.NotificationService) *Checker {
+	ctx, cancel := context.WithCancel(context.Background())
 	return &Checker{
+		renotifyInterval:    config.RenotifyInterval,
+		pollCurve:           config.PollCurve,
+		checkInterval:       config.CheckInterval,
+		workers:             config.CheckWorkers,
+		checkTimeout:        config.CheckTimeout,
+		instanceID:          config.InstanceID,
+		leaseTTL:            config.LeaseTTL,
 		trainClient:         client,
-		database:            database,
+		store:               store,
 		segmentationService: segmentationService,
-		discordService:      discordService,
+		notificationService: notificationService,
+		inFlight:            make(map[string]bool),
+		ctx:                 ctx,
+		cancel:              cancel,
+		stop:                make(chan struct{}),
 	}
 }
 
-func (c *Checker) handleKey(key string) {
-	value, err := c.database.RedisClient.Get(context.Background(), key).Result()
+// check takes the lease of the watchdog, loads its current state, checks it
+// within the check timeout and schedules its next check.
+func (c *Checker) check(id string) {
+	acquired, err := c.store.AcquireLease(c.ctx, id, c.instanceID, c.leaseTTL)
 	if err != nil {
-		log.Println("Failed to fetch value for key", key, ":", err)
+		log.Println("Failed to acquire lease of watchdog", id, ":", err)
+		return
+	}
+	if !acquired {
+		// checked by another instance
+		return
+	}
+	defer func() {
+		if err := c.store.ReleaseLease(context.Background(), id, c.instanceID); err != nil {
+			log.Println("Failed to release lease of watchdog", id, ":", err)
+		}
+	}()
+
+	leaseCtx, cancelLease := context.WithCancelCause(c.ctx)
+	defer cancelLease(nil)
+	go c.renewLease(leaseCtx, cancelLease, id)
+
+	ctx := leaseCtx
+	if c.checkTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.checkTimeout)
+		defer cancel()
+	}
+
+	watchdog, err := c.store.GetWatchdog(ctx, id)
+	if errors.Is(err, databasepkg.ErrWatchdogNotFound) {
+		// deleted or expired since it was listed
 		return
 	}
 
-	webhookURL, stationFromID, stationToID, routeIDStr, err := c.parseValue(value)
+	next := time.Now().Add(c.checkInterval)
 	if err != nil {
-		log.Println("Failed to parse value:", err)
+		log.Println("Failed to fetch watchdog", id, ":", err)
+	} else {
+		c.handleWatchdog(ctx, *watchdog)
+		next = time.Now().Add(c.interval(*watchdog))
+	}
+
+	if errors.Is(context.Cause(leaseCtx), errLeaseLost) {
+		// the instance that holds the lease now schedules the watchdog
+		return
+	}
+	if c.ctx.Err() != nil {
+		// stopping, the watchdog is due again when its claim expires
 		return
 	}
+	// the check may have used up ctx
+	if err := c.store.ScheduleCheck(context.Background(), id, next); err != nil {
+		log.Println("Failed to schedule watchdog", id, ":", err)
+	}
+}
+
+// renewLease keeps the lease of the watchdog until ctx is done, cancelling ctx
+// if the lease cannot be renewed.
+func (c *Checker) renewLease(ctx context.Context, cancel context.CancelCauseFunc, id string) {
+	ticker := time.NewTicker(c.leaseTTL / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			renewed, err := c.store.RenewLease(ctx, id, c.instanceID, c.leaseTTL)
+			if ctx.Err() != nil {
+				return
+			}
+			if err != nil || !renewed {
+				log.Println("Lost lease of watchdog", id, ":", err)
+				cancel(errLeaseLost)
+				return
+			}
+		}
+	}
+}
+
+// interval returns how long to wait before the next check of the watchdog:
+// its own interval if it has one, otherwise the step of the poll curve for the
+// time left to departure.
+func (c *Checker) interval(watchdog models.Watchdog) time.Duration {
+	if watchdog.CheckIntervalSeconds > 0 {
+		return time.Duration(watchdog.CheckIntervalSeconds) * time.Second
+	}
+
+	departure := watchdog.DepartureTime
+	if departure.IsZero() {
+		// watchdogs created before the departure was stored expire at departure
+		departure = watchdog.ExpiresAt
+	}
+	if len(c.pollCurve) == 0 || departure.IsZero() {
+		return c.checkInterval
+	}
 
-	routeDetails, freeSeatsResponse, err := c.fetchRouteDetails(routeIDStr, stationFromID, stationToID)
+	untilDeparture := time.Until(departure)
+	for _, step := range c.pollCurve {
+		if untilDeparture >= step.Before {
+			return step.Interval
+		}
+	}
+	return c.pollCurve[len(c.pollCurve)-1].Interval
+}
+
+func (c *Checker) handleWatchdog(ctx context.Context, watchdog models.Watchdog) {
+	routeDetails, freeSeatsResponse, err := c.fetchRouteDetails(ctx, watchdog.RouteID, watchdog.StationFromID, watchdog.StationToID)
+	if ctx.Err() != nil {
+		log.Println("Check of watchdog", watchdog.ID, "was cancelled:", context.Cause(ctx))
+		return
+	}
 	if err != nil {
-		log.Println("Failed to fetch route details or free seats:", err)
+		// the availability is unknown, which is no change to notify
+		log.Println("Skipping check of watchdog", watchdog.ID, "- failed to fetch route details or free seats:", err)
+		return
+	}
+
+	alternatives, searchErr := c.findAlternativeSegments(ctx, watchdog, routeDetails.DepartureTime)
+	if searchErr != nil {
+		log.Println("Failed to fetch available segments:", searchErr)
 	}
 
-	if routeDetails != nil && routeDetails.FreeSeatsCount > 0 {
-		if freeSeatsResponse != nil {
-			c.discordService.NotifyDiscord(*freeSeatsResponse, *routeDetails, routeDetails.DepartureTime, webhookURL)
-			c.notifyAlternativeSegments(routeIDStr, stationFromID, stationToID, routeDetails.DepartureTime, webhookURL)
+	if ctx.Err() != nil {
+		// the availability may be outdated already, the next check notifies it
+		log.Println("Check of watchdog", watchdog.ID, "was cancelled:", context.Cause(ctx))
+		return
+	}
+
+	snapshot := newSnapshot(*routeDetails, freeSeatsResponse, alternatives)
+	if searchErr != nil && watchdog.LastSnapshot != nil {
+		// a failed search is not a change of the alternatives
+		snapshot.Alternatives = watchdog.LastSnapshot.Alternatives
+	}
+
+	previous := watchdog.LastSnapshot
+	renotify := c.renotifyInterval > 0 && watchdog.LastNotifiedAt != nil && time.Since(*watchdog.LastNotifiedAt) >= c.renotifyInterval
+
+	// The seats and the alternatives are queued separately. A part that
+	// failed to be queued keeps its previous state in the recorded snapshot,
+	// so only its change is notified again.
+	recorded := snapshot
+	notified := false
+	if snapshot.FreeSeatsCount > 0 && (renotify || seatsChanged(previous, snapshot)) {
+		if err := c.notificationService.NotifyFreeSeats(ctx, watchdog, *freeSeatsResponse, *routeDetails); err != nil {
+			keepSeats(&recorded, previous)
 		} else {
-			fmt.Printf("Free seats count is %d, but free seats response is nil\n", routeDetails.FreeSeatsCount)
+			notified = true
+		}
+	} else if snapshot.FreeSeatsCount == 0 && previous != nil && previous.FreeSeatsCount > 0 {
+		if err := c.notificationService.NotifySoldOut(ctx, watchdog, *routeDetails); err != nil {
+			keepSeats(&recorded, previous)
+		} else {
+			notified = true
+		}
+	}
+	if searchErr == nil && len(alternatives) > 0 && (renotify || alternativesChanged(previous, snapshot)) {
+		if err := c.notificationService.NotifyAlternatives(ctx, watchdog, alternatives); err != nil {
+			keepAlternatives(&recorded, previous)
+		} else {
+			notified = true
 		}
-	} else if routeDetails != nil {
-		c.notifyAlternativeSegments(routeIDStr, stationFromID, stationToID, routeDetails.DepartureTime, webhookURL)
-	} else {
-		fmt.Printf("Free seats count is 0, but route details are nil - %v\n", routeDetails)
 	}
 
-}
+	if !notified && !seatsChanged(previous, recorded) && !alternativesChanged(previous, recorded) {
+		return
+	}
 
-func (c *Checker) parseValue(value string) (webhookURL, stationFromID, stationToID, routeIDStr string, err error) {
-	parts := strings.Split(value, ";;")
-	if len(parts) != 4 {
-		return "", "", "", "", errors.New("Invalid value format")
+	var notifiedAt *time.Time
+	if notified {
+		notifiedAt = &recorded.ObservedAt
+	}
+	// only the snapshot is written back, the watchdog may have been updated
+	// or deleted during the check
+	err = c.store.RecordCheck(ctx, watchdog.ID, recorded, notifiedAt)
+	if errors.Is(err, databasepkg.ErrWatchdogNotFound) {
+		return
+	}
+	if err != nil {
+		log.Println("Failed to update watchdog", watchdog.ID, ":", err)
 	}
-	return parts[0], parts[1], parts[2], parts[3], nil
 }
 
-func (c *Checker) fetchRouteDetails(routeIDStr, stationFromID, stationToID string) (*models.RouteDetails, *models.FreeSeatsResponse, error) {
+func (c *Checker) fetchRouteDetails(ctx context.Context, routeIDStr, stationFromID, stationToID string) (*models.RouteDetails, *models.FreeSeatsResponse, error) {
 	routeID, err := strconv.Atoi(routeIDStr)
 	if err != nil {
 		return nil, nil, err
 	}
 
-	freeSeatsResponse, err := c.trainClient.GetFreeSeats(routeID, stationFromID, stationToID)
-	routeDetails, err := c.trainClient.GetRouteDetails(routeID, stationFromID, stationToID)
-	return routeDetails, &freeSeatsResponse, err
+	freeSeatsResponse, err := c.trainClient.GetFreeSeats(ctx, routeID, stationFromID, stationToID)
+	if err != nil {
+		return nil, nil, err
+	}
+	routeDetails, err := c.trainClient.GetRouteDetails(ctx, routeID, stationFromID, stationToID)
+	if err != nil {
+		return nil, nil, err
+	}
+	return routeDetails, &freeSeatsResponse, nil
 }
 
-func (c *Checker) notifyAlternativeSegments(routeIDStr, stationFromID, stationToID, departureTimeStr, webhookURL string) {
+func (c *Checker) findAlternativeSegments(ctx context.Context, watchdog models.Watchdog, departureTimeStr string) ([]models.SegmentPath, error) {
 	departureTime, _ := time.Parse(time.RFC3339, departureTimeStr)
 	departureDate := departureTime.Format("02.01.2006")
-	availableSegments, err := c.segmentationService.FindAvailableSegments(routeIDStr, stationFromID, stationToID, departureDate)
-	if err != nil {
-		log.Println("Failed to fetch available segments:", err)
-		return
-	}
-	if len(availableSegments) > 0 {
-		c.discordService.NotifyDiscordAlternatives(availableSegments, webhookURL)
+	return c.segmentationService.FindAvailableSegments(ctx, watchdog.RouteID, watchdog.StationFromID, watchdog.StationToID, departureDate, watchdog.Ranking)
+}
+
+func (c *Checker) start() {
+	jobs := make(chan string)
+	for i := 0; i < c.workers; i++ {
+		c.wg.Add(1)
+		go c.worker(jobs)
 	}
+	go c.periodicallyCheck(jobs)
 }
 
-func (c *Checker) periodicallyCheck() {
-	ticker := time.NewTicker(1 * time.Minute)
+func (c *Checker) periodicallyCheck(jobs chan<- string) {
+	defer close(jobs)
+
+	ticker := time.NewTicker(pollInterval)
 	defer ticker.Stop()
 
 	for {
 		select {
+		case <-c.stop:
+			return
 		case <-ticker.C:
-			keys, err := c.database.RedisClient.Keys(context.Background(), "watchdog:*").Result()
-			if err != nil {
-				log.Println("Failed to fetch keys:", err)
+			if c.pause() {
+				continue
+			}
+			c.dispatch(jobs)
+		}
+	}
+}
+
+// dispatch hands the due watchdogs to the workers. It claims no more
+// watchdogs at once than there are workers, so claimed watchdogs do not wait
+// long for a free worker.
+func (c *Checker) dispatch(jobs chan<- string) {
+	for {
+		// A watchdog whose check is cut short by a crash is due again when
+		// its lease has expired.
+		ids, err := c.store.ClaimDueWatchdogs(c.ctx, time.Now(), c.workers, c.leaseTTL)
+		if err != nil {
+			log.Println("Failed to fetch due watchdogs:", err)
+			return
+		}
+
+		for _, id := range ids {
+			if !c.claim(id) {
+				log.Println("Skipping watchdog", id, "- previous check is still running")
 				continue
 			}
-			for _, key := range keys {
-				c.handleKey(key)
+
+			select {
+			case jobs <- id:
+			case <-c.stop:
+				c.release(id)
+				return
 			}
 		}
+
+		if len(ids) < c.workers {
+			return
+		}
+	}
+}
+
+// pause reports whether checks are paused because the RegioJet API is
+// unavailable. The due watchdogs wait in the store until it recovers. Only the
+// dispatching goroutine uses paused.
+func (c *Checker) pause() bool {
+	until := c.trainClient.UnavailableUntil()
+	if time.Now().Before(until) {
+		if !c.paused {
+			log.Println("RegioJet API is unavailable, pausing checks until", until.Format(time.RFC3339))
+			c.paused = true
+		}
+		return true
 	}
+
+	if c.paused {
+		log.Println("Resuming checks")
+		c.paused = false
+	}
+	return false
+}
+
+func (c *Checker) worker(jobs <-chan string) {
+	defer c.wg.Done()
+
+	for id := range jobs {
+		c.check(id)
+		c.release(id)
+	}
+}
+
+func (c *Checker) claim(id string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.inFlight[id] {
+		return false
+	}
+	c.inFlight[id] = true
+	return true
+}
+
+func (c *Checker) release(id string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.inFlight, id)
 }
 
 func RegisterCheckerHooks(lc fx.Lifecycle, checker *Checker) {
 	lc.Append(fx.Hook{
 		OnStart: func(context.Context) error {
-			go checker.periodicallyCheck()
+			checker.start()
+			return nil
+		},
+		OnStop: func(ctx context.Context) error {
+			close(checker.stop)
+			checker.cancel()
+
+			done := make(chan struct{})
+			go func() {
+				checker.wg.Wait()
+				close(done)
+			}()
+			select {
+			case <-done:
+			case <-ctx.Done():
+			}
 			return nil
 		},
-		OnStop: nil,
 	})
 }
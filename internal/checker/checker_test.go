@@ -0,0 +1,252 @@
+package checker
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	clientpkg "github.com/bxxf/regiojet-watchdog/internal/client"
+	configpkg "github.com/bxxf/regiojet-watchdog/internal/config"
+	"github.com/bxxf/regiojet-watchdog/internal/constants"
+	databasepkg "github.com/bxxf/regiojet-watchdog/internal/database"
+	"github.com/bxxf/regiojet-watchdog/internal/fakeregiojet"
+	"github.com/bxxf/regiojet-watchdog/internal/models"
+	notifierpkg "github.com/bxxf/regiojet-watchdog/internal/notifier"
+	segmentationpkg "github.com/bxxf/regiojet-watchdog/internal/segmentation"
+	"go.uber.org/zap"
+)
+
+// deletingStore deletes every watchdog right after the check has loaded it.
+type deletingStore struct {
+	*databasepkg.MemoryStore
+}
+
+func (s deletingStore) GetWatchdog(ctx context.Context, id string) (*models.Watchdog, error) {
+	watchdog, err := s.MemoryStore.GetWatchdog(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return watchdog, s.MemoryStore.DeleteWatchdog(ctx, id)
+}
+
+// failingStore fails to queue notifications of one kind.
+type failingStore struct {
+	*databasepkg.MemoryStore
+	kind     string
+	attempts int
+}
+
+func (s *failingStore) EnqueueNotification(ctx context.Context, notification models.Notification) error {
+	if notification.Kind == s.kind {
+		s.attempts++
+		return errors.New("failed to queue")
+	}
+	return s.MemoryStore.EnqueueNotification(ctx, notification)
+}
+
+// testNotifier accepts every target of its type and sends nothing, the
+// notifications stay queued.
+type testNotifier struct{}
+
+func (testNotifier) Type() string { return "test" }
+
+func (testNotifier) Validate(models.NotificationTarget) error { return nil }
+
+func (testNotifier) NotifyFreeSeats(context.Context, models.Watchdog, models.NotificationTarget, models.FreeSeatsResponse, models.RouteDetails) error {
+	return nil
+}
+
+func (testNotifier) NotifySoldOut(context.Context, models.Watchdog, models.NotificationTarget, models.RouteDetails) error {
+	return nil
+}
+
+func (testNotifier) NotifyAlternatives(context.Context, models.Watchdog, models.NotificationTarget, []models.SegmentPath) error {
+	return nil
+}
+
+// newTestChecker returns a checker of the watchdogs in store against the
+// RegioJet API served by api.
+func newTestChecker(t *testing.T, store databasepkg.Store, api http.Handler) *Checker {
+	server := httptest.NewServer(api)
+	t.Cleanup(server.Close)
+
+	config := configpkg.Config{
+		RegioJetAPIURL:        server.URL,
+		UpstreamTimeout:       10 * time.Second,
+		CheckInterval:         time.Minute,
+		CheckWorkers:          1,
+		CheckTimeout:          time.Minute,
+		SegmentationWorkers:   2,
+		AlternativesObjective: models.ObjectiveChanges,
+		AlternativesLimit:     5,
+		MinTransferTime:       5 * time.Minute,
+		InstanceID:            "test",
+		LeaseTTL:              time.Minute,
+	}
+	logger := zap.NewNop()
+	trainClient := clientpkg.NewTrainClient(logger, config)
+	segmentation, err := segmentationpkg.NewSegmentationService(config, trainClient, constants.NewConstantsClient(logger, config))
+	if err != nil {
+		t.Fatal(err)
+	}
+	notifications := notifierpkg.NewNotificationService(notifierpkg.NotificationParams{
+		Logger:    logger,
+		Store:     store,
+		Notifiers: []notifierpkg.Notifier{testNotifier{}},
+	})
+	return NewChecker(config, store, segmentation, trainClient, notifications)
+}
+
+// newTestWatchdog returns a watchdog of train 1010 of the default fixture
+// tomorrow, notifying a test target.
+func newTestWatchdog(id string) models.Watchdog {
+	tomorrow := time.Now().AddDate(0, 0, 1)
+	date, _ := strconv.Atoi(tomorrow.Format("20060102"))
+	return models.Watchdog{
+		ID:            id,
+		StationFromID: "372825000",
+		StationToID:   "508808000",
+		RouteID:       strconv.Itoa(1010*100000000 + date),
+		Targets:       []models.NotificationTarget{{Type: "test"}},
+		CreatedAt:     time.Now(),
+		ExpiresAt:     tomorrow.Add(24 * time.Hour),
+	}
+}
+
+// queued returns the notifications waiting in the store.
+func queued(t *testing.T, store databasepkg.Store) []models.Notification {
+	notifications, err := store.ClaimNotifications(context.Background(), time.Now().Add(time.Hour), 100, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return notifications
+}
+
+func TestWatchdogDeletedDuringCheck(t *testing.T) {
+	store := deletingStore{databasepkg.NewMemoryStore()}
+	checker := newTestChecker(t, store, fakeregiojet.NewServer(fakeregiojet.DefaultFixture()))
+
+	ctx := context.Background()
+	if err := store.SaveWatchdog(ctx, newTestWatchdog("a")); err != nil {
+		t.Fatal(err)
+	}
+
+	checker.check("a")
+
+	if _, err := store.MemoryStore.GetWatchdog(ctx, "a"); !errors.Is(err, databasepkg.ErrWatchdogNotFound) {
+		t.Errorf("got %v, want the watchdog to stay deleted", err)
+	}
+	if ids, err := store.ClaimDueWatchdogs(ctx, time.Now().Add(time.Hour), 10, time.Minute); err != nil || len(ids) != 0 {
+		t.Errorf("claimed %v, %v, want no watchdogs", ids, err)
+	}
+}
+
+func TestFailedFetchIsNoChange(t *testing.T) {
+	store := databasepkg.NewMemoryStore()
+	fake := fakeregiojet.NewServer(fakeregiojet.DefaultFixture())
+	checker := newTestChecker(t, store, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/freeSeats") {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		fake.ServeHTTP(w, r)
+	}))
+
+	ctx := context.Background()
+	watchdog := newTestWatchdog("a")
+	watchdog.LastSnapshot = &models.AvailabilitySnapshot{
+		FreeSeatsCount: 2,
+		FreeSeats:      map[string]int{"1/C0": 2},
+		ObservedAt:     time.Now().Add(-time.Minute),
+	}
+	if err := store.SaveWatchdog(ctx, watchdog); err != nil {
+		t.Fatal(err)
+	}
+
+	checker.check("a")
+
+	saved, err := store.GetWatchdog(ctx, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.LastSnapshot == nil || saved.LastSnapshot.FreeSeatsCount != 2 {
+		t.Errorf("got snapshot %+v, want the previous one kept", saved.LastSnapshot)
+	}
+	if notifications := queued(t, store); len(notifications) != 0 {
+		t.Errorf("queued %d notifications, want none", len(notifications))
+	}
+}
+
+func TestSoldOutIsNotified(t *testing.T) {
+	store := databasepkg.NewMemoryStore()
+	// train 1010 of the default fixture has no free seats for the whole route
+	checker := newTestChecker(t, store, fakeregiojet.NewServer(fakeregiojet.DefaultFixture()))
+
+	ctx := context.Background()
+	watchdog := newTestWatchdog("a")
+	watchdog.LastSnapshot = &models.AvailabilitySnapshot{
+		FreeSeatsCount: 1,
+		FreeSeats:      map[string]int{"1/C0": 1},
+		ObservedAt:     time.Now().Add(-time.Minute),
+	}
+	if err := store.SaveWatchdog(ctx, watchdog); err != nil {
+		t.Fatal(err)
+	}
+
+	kinds := func() map[string]int {
+		kinds := make(map[string]int)
+		for _, notification := range queued(t, store) {
+			kinds[notification.Kind]++
+			store.DeleteNotification(ctx, notification.ID)
+		}
+		return kinds
+	}
+
+	checker.check("a")
+	if got := kinds(); got[models.NotificationSoldOut] != 1 || got[models.NotificationFreeSeats] != 0 {
+		t.Errorf("queued %v, want one sold out notification", got)
+	}
+	saved, err := store.GetWatchdog(ctx, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.LastSnapshot == nil || saved.LastSnapshot.FreeSeatsCount != 0 || saved.LastNotifiedAt == nil {
+		t.Errorf("got snapshot %+v notified at %v, want the sold out route recorded", saved.LastSnapshot, saved.LastNotifiedAt)
+	}
+
+	checker.check("a")
+	if got := kinds(); got[models.NotificationSoldOut] != 0 {
+		t.Errorf("queued %v checking the sold out route again, want no sold out notification", got)
+	}
+}
+
+func TestQueuedPartIsNotRepeated(t *testing.T) {
+	store := &failingStore{MemoryStore: databasepkg.NewMemoryStore(), kind: models.NotificationAlternatives}
+	checker := newTestChecker(t, store, fakeregiojet.NewServer(fakeregiojet.DefaultFixture()))
+
+	ctx := context.Background()
+	watchdog := newTestWatchdog("a")
+	watchdog.LastSnapshot = &models.AvailabilitySnapshot{
+		FreeSeatsCount: 1,
+		FreeSeats:      map[string]int{"1/C0": 1},
+		ObservedAt:     time.Now().Add(-time.Minute),
+	}
+	if err := store.SaveWatchdog(ctx, watchdog); err != nil {
+		t.Fatal(err)
+	}
+
+	checker.check("a")
+	checker.check("a")
+
+	if notifications := queued(t, store); len(notifications) != 1 || notifications[0].Kind != models.NotificationSoldOut {
+		t.Errorf("queued %d notifications, want only the sold out one", len(notifications))
+	}
+	if store.attempts != 2 {
+		t.Errorf("queued the alternatives %d times, want them retried by the second check", store.attempts)
+	}
+}
@@ -0,0 +1,94 @@
+package checker
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bxxf/regiojet-watchdog/internal/models"
+)
+
+func newSnapshot(routeDetails models.RouteDetails, freeSeats *models.FreeSeatsResponse, alternatives []models.SegmentPath) models.AvailabilitySnapshot {
+	snapshot := models.AvailabilitySnapshot{
+		FreeSeatsCount: routeDetails.FreeSeatsCount,
+		FreeSeats:      make(map[string]int),
+		Alternatives:   hashAlternatives(alternatives),
+		ObservedAt:     time.Now(),
+	}
+
+	if freeSeats != nil {
+		for _, section := range *freeSeats {
+			for _, vehicle := range section.Vehicles {
+				for _, seat := range vehicle.FreeSeats {
+					snapshot.FreeSeats[fmt.Sprintf("%d/%s", vehicle.VehicleNumber, seat.SeatClass)]++
+				}
+			}
+		}
+	}
+
+	return snapshot
+}
+
+func seatsChanged(previous *models.AvailabilitySnapshot, current models.AvailabilitySnapshot) bool {
+	if previous == nil {
+		return true
+	}
+	if previous.FreeSeatsCount != current.FreeSeatsCount || len(previous.FreeSeats) != len(current.FreeSeats) {
+		return true
+	}
+	for key, count := range current.FreeSeats {
+		if previous.FreeSeats[key] != count {
+			return true
+		}
+	}
+	return false
+}
+
+// keepSeats sets the free seats of the snapshot to the previous ones, or to
+// none if there are no previous ones.
+func keepSeats(snapshot *models.AvailabilitySnapshot, previous *models.AvailabilitySnapshot) {
+	snapshot.FreeSeatsCount = 0
+	snapshot.FreeSeats = make(map[string]int)
+	if previous != nil {
+		snapshot.FreeSeatsCount = previous.FreeSeatsCount
+		snapshot.FreeSeats = previous.FreeSeats
+	}
+}
+
+// keepAlternatives sets the alternatives of the snapshot to the previous
+// ones, or to none if there are no previous ones.
+func keepAlternatives(snapshot *models.AvailabilitySnapshot, previous *models.AvailabilitySnapshot) {
+	snapshot.Alternatives = ""
+	if previous != nil {
+		snapshot.Alternatives = previous.Alternatives
+	}
+}
+
+func alternativesChanged(previous *models.AvailabilitySnapshot, current models.AvailabilitySnapshot) bool {
+	return previous == nil || previous.Alternatives != current.Alternatives
+}
+
+// hashAlternatives hashes the stations and trains of every alternative route.
+// Free seat counts and prices of the segments are left out, so only a
+// different set of routes counts as a change.
+func hashAlternatives(paths []models.SegmentPath) string {
+	if len(paths) == 0 {
+		return ""
+	}
+
+	routes := make([]string, 0, len(paths))
+	for _, path := range paths {
+		var stations []string
+		for _, segment := range path.Segments {
+			stations = append(stations, segment.RouteID+":"+segment.FromStationName+">"+segment.ToStationName)
+		}
+		routes = append(routes, strings.Join(stations, "|"))
+	}
+	sort.Strings(routes)
+
+	sum := sha256.Sum256([]byte(strings.Join(routes, "\n")))
+	return hex.EncodeToString(sum[:])
+}
@@ -2,87 +2,202 @@ package client
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"math/rand"
 	"net/http"
 	"strconv"
 	"time"
 
+	"github.com/bxxf/regiojet-watchdog/internal/config"
 	"github.com/bxxf/regiojet-watchdog/internal/models"
+	"github.com/bxxf/regiojet-watchdog/internal/recorder"
+	"go.uber.org/fx"
 	"go.uber.org/zap"
 )
 
-const baseURL = "https://brn-ybus-pubapi.sa.cz/restapi"
-
+// maxRetryBackoff caps the delay between retries. A request the API asks to
+// wait longer for is not retried.
+const maxRetryBackoff = 10 * time.Second
+
+// TrainClient queries the RegioJet API. Requests shared by several callers
+// run until they time out, all of the callers have given up on them or the
+// client is stopped.
+//
+// Failed connections, server errors and rate limiting are retried with
+// backoff. After too many failures in a row, the circuit breaker fails all
+// requests with ErrCircuitOpen for a while.
 type TrainClient struct {
-	logger *zap.Logger
-	client *http.Client
+	logger       *zap.Logger
+	client       *http.Client
+	baseURL      string
+	limiter      *limiter
+	coalescer    *coalescer
+	breaker      *breaker
+	maxRetries   int
+	retryBackoff time.Duration
+	ctx          context.Context
+	cancel       context.CancelFunc
 }
 
-func NewTrainClient(logger *zap.Logger) *TrainClient {
+func NewTrainClient(logger *zap.Logger, config config.Config) *TrainClient {
+	ctx, cancel := context.WithCancel(context.Background())
 	return &TrainClient{
 		logger: logger,
-		client: &http.Client{},
+		client: &http.Client{
+			Timeout:   config.UpstreamTimeout,
+			Transport: recorder.NewTransport(config.UpstreamRecording, config.UpstreamRecordingsDir, http.DefaultTransport),
+		},
+		baseURL:      config.RegioJetAPIURL,
+		limiter:      newLimiter(config.UpstreamRequestsPerMinute, config.UpstreamBurst),
+		coalescer:    newCoalescer(config.UpstreamCacheTTL),
+		breaker:      newBreaker(config.UpstreamBreakerThreshold, config.UpstreamBreakerCooldown),
+		maxRetries:   config.UpstreamMaxRetries,
+		retryBackoff: config.UpstreamRetryBackoff,
+		ctx:          ctx,
+		cancel:       cancel,
 	}
 }
 
-func (c *TrainClient) makeAPIRequest(method, url string, body []byte, headers map[string]string) (*http.Response, error) {
-	req, err := http.NewRequest(method, baseURL+url, bytes.NewBuffer(body))
-	if err != nil {
-		return nil, err
+// shared returns the context of a request made for several callers. It lasts
+// at most as long as the client and keeps the priority of the caller that
+// made it.
+func (c *TrainClient) shared(ctx context.Context) context.Context {
+	return WithPriority(c.ctx, priorityOf(ctx))
+}
+
+// UnavailableUntil returns until when the circuit breaker fails requests, or
+// the zero time if the API is available.
+func (c *TrainClient) UnavailableUntil() time.Time {
+	return c.breaker.until()
+}
+
+// makeAPIRequest makes the request and decodes the JSON response into out,
+// retrying it while it fails with a retryable error.
+func (c *TrainClient) makeAPIRequest(ctx context.Context, method, url string, body []byte, headers map[string]string, out interface{}) error {
+	for attempt := 1; ; attempt++ {
+		err := c.attempt(ctx, method, url, body, headers, out)
+		if err == nil || attempt > c.maxRetries || !retryable(err) || ctx.Err() != nil {
+			return err
+		}
+
+		delay := c.retryDelay(attempt)
+		var upstreamErr *UpstreamError
+		if errors.As(err, &upstreamErr) && upstreamErr.RetryAfter > delay {
+			if upstreamErr.RetryAfter > maxRetryBackoff {
+				return err
+			}
+			delay = upstreamErr.RetryAfter
+		}
+		c.logger.Warn("Retrying RegioJet API request", zap.String("url", url), zap.Int("attempt", attempt), zap.Duration("retryIn", delay), zap.Error(err))
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		}
 	}
+}
 
-	for key, value := range headers {
-		req.Header.Set(key, value)
+// attempt makes the request once, if the circuit breaker allows it.
+func (c *TrainClient) attempt(ctx context.Context, method, url string, body []byte, headers map[string]string, out interface{}) error {
+	if !c.breaker.allow() {
+		return ErrCircuitOpen
 	}
 
-	return c.client.Do(req)
+	err := c.do(ctx, method, url, body, headers, out)
+	switch {
+	case err == nil:
+		c.breaker.success()
+	case ctx.Err() != nil:
+		c.breaker.abandon()
+	case retryable(err):
+		c.breaker.failure()
+	default:
+		// the API works, the request is wrong
+		c.breaker.success()
+	}
+	return err
 }
 
-func (c *TrainClient) FetchRoutes(stationFromID, stationToID, departureDate, currency string) ([]models.Route, error) {
-	parsedDepartureDate, err := time.Parse("02.01.2006", departureDate)
+func (c *TrainClient) do(ctx context.Context, method, url string, body []byte, headers map[string]string, out interface{}) error {
+	if err := c.limiter.wait(ctx); err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+url, bytes.NewReader(body))
 	if err != nil {
-		return nil, err
+		return err
 	}
-	formattedDepartureDate := parsedDepartureDate.Format("2006-01-02")
-	urlPath := fmt.Sprintf("/routes/search/simple?fromLocationId=%s&fromLocationType=STATION&toLocationId=%s&toLocationType=STATION&departureDate=%s",
-		stationFromID,
-		stationToID,
-		formattedDepartureDate,
-	)
 
-	headers := map[string]string{
-		"X-Currency": currency,
+	for key, value := range headers {
+		req.Header.Set(key, value)
 	}
-	resp, err := c.makeAPIRequest("GET", urlPath, nil, headers)
+
+	resp, err := c.client.Do(req)
 	if err != nil {
-		fmt.Printf("error in fetching routes %+v\n", err)
-		return nil, err
+		return err
 	}
 	defer resp.Body.Close()
 
-	var responseJson models.Response
-	if err := json.NewDecoder(resp.Body).Decode(&responseJson); err != nil {
-		return nil, err
+	// read at once, so the error message can be decoded from the same body
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
 	}
 
-	var routes []models.Route
-	for _, ticket := range responseJson.Routes {
-		vehicleType := ticket.VehicleTypes[0]
-		containsBus := false
-		if vehicleType == "BUS" {
-			containsBus = true
-			break
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		var apiError struct {
+			Message string `json:"message"`
 		}
+		// the message is optional, the status code tells the kind of error
+		json.Unmarshal(data, &apiError)
+		return newStatusError(resp, apiError.Message)
+	}
+
+	if err := json.Unmarshal(data, out); err != nil {
+		return &UpstreamError{Kind: ErrDecode, StatusCode: resp.StatusCode, Message: err.Error()}
+	}
+	return nil
+}
+
+// retryDelay returns the backoff after the given number of failed attempts,
+// with jitter so requests that failed together are not retried together.
+func (c *TrainClient) retryDelay(attempts int) time.Duration {
+	delay := c.retryBackoff
+	for i := 1; i < attempts && delay < maxRetryBackoff; i++ {
+		delay *= 2
+	}
+	if delay > maxRetryBackoff {
+		delay = maxRetryBackoff
+	}
+	if delay <= 0 {
+		return 0
+	}
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+func (c *TrainClient) FetchRoutes(ctx context.Context, stationFromID, stationToID, departureDate, currency string) ([]models.Route, error) {
+	tickets, err := c.searchRoutes(ctx, stationFromID, stationToID, departureDate, currency)
+	if err != nil {
+		return nil, err
+	}
 
-		if containsBus {
+	var routes []models.Route
+	for _, ticket := range tickets {
+		if containsBus(ticket.VehicleTypes) {
 			continue
 		}
 
 		departureTime, err := time.Parse(time.RFC3339, ticket.DepartureTime)
 		if err != nil {
-			c.logger.Fatal("Failed to parse departure time", zap.Error(err))
+			c.logger.Warn("Skipping route with invalid departure time", zap.String("route", ticket.ID), zap.Error(err))
+			continue
 		}
 
 		if departureTime.Format("02.01.2006") != departureDate {
@@ -97,7 +212,8 @@ func (c *TrainClient) FetchRoutes(stationFromID, stationToID, departureDate, cur
 
 		arrivalTime, err := time.Parse(time.RFC3339, ticket.ArrivalTime)
 		if err != nil {
-			c.logger.Fatal("Failed to parse arrival time", zap.Error(err))
+			c.logger.Warn("Skipping route with invalid arrival time", zap.String("route", ticket.ID), zap.Error(err))
+			continue
 		}
 
 		arrivalString := arrivalTime.Format("15:04")
@@ -116,17 +232,88 @@ func (c *TrainClient) FetchRoutes(stationFromID, stationToID, departureDate, cur
 	return routes, nil
 }
 
-func (c *TrainClient) fetchFreeSeats(routeId int, seatclass, stationFromID, stationToID string) (*models.FreeSeatsResponse, *models.FreeSeatsError) {
+// FetchDirectRoutes returns the trains going from the first station to the
+// second one on the departure date without a transfer. It shares the search
+// with FetchRoutes.
+func (c *TrainClient) FetchDirectRoutes(ctx context.Context, stationFromID, stationToID, departureDate, currency string) ([]models.TrainTicket, error) {
+	tickets, err := c.searchRoutes(ctx, stationFromID, stationToID, departureDate, currency)
+	if err != nil {
+		return nil, err
+	}
+
+	var direct []models.TrainTicket
+	for _, ticket := range tickets {
+		if ticket.TransfersCount > 0 || !trainsOnly(ticket.VehicleTypes) {
+			continue
+		}
+
+		departureTime, err := time.Parse(time.RFC3339, ticket.DepartureTime)
+		if err != nil || departureTime.Format("02.01.2006") != departureDate {
+			continue
+		}
+		direct = append(direct, ticket)
+	}
+	return direct, nil
+}
+
+func containsBus(vehicleTypes []string) bool {
+	for _, vehicleType := range vehicleTypes {
+		if vehicleType == "BUS" {
+			return true
+		}
+	}
+	return false
+}
+
+func trainsOnly(vehicleTypes []string) bool {
+	for _, vehicleType := range vehicleTypes {
+		if vehicleType != "TRAIN" {
+			return false
+		}
+	}
+	return len(vehicleTypes) > 0
+}
+
+func (c *TrainClient) searchRoutes(ctx context.Context, stationFromID, stationToID, departureDate, currency string) ([]models.TrainTicket, error) {
+	key := fmt.Sprintf("routes/%s/%s/%s/%s", stationFromID, stationToID, departureDate, currency)
+	return coalesce(ctx, c.coalescer, key, c.shared(ctx), func(ctx context.Context) ([]models.TrainTicket, error) {
+		return c.fetchRoutes(ctx, stationFromID, stationToID, departureDate, currency)
+	})
+}
+
+func (c *TrainClient) fetchRoutes(ctx context.Context, stationFromID, stationToID, departureDate, currency string) ([]models.TrainTicket, error) {
+	parsedDepartureDate, err := time.Parse("02.01.2006", departureDate)
+	if err != nil {
+		return nil, err
+	}
+	formattedDepartureDate := parsedDepartureDate.Format("2006-01-02")
+	urlPath := fmt.Sprintf("/routes/search/simple?fromLocationId=%s&fromLocationType=STATION&toLocationId=%s&toLocationType=STATION&departureDate=%s",
+		stationFromID,
+		stationToID,
+		formattedDepartureDate,
+	)
+
+	headers := map[string]string{
+		"X-Currency": currency,
+	}
+	var responseJson models.Response
+	if err := c.makeAPIRequest(ctx, "GET", urlPath, nil, headers, &responseJson); err != nil {
+		return nil, err
+	}
+	return responseJson.Routes, nil
+}
+
+func (c *TrainClient) fetchFreeSeats(ctx context.Context, routeId int, seatclass, stationFromID, stationToID string) (*models.FreeSeatsResponse, error) {
 	urlPath := fmt.Sprintf("/routes/%d/freeSeats", routeId)
 
 	fromStationId, err := strconv.Atoi(stationFromID)
 	if err != nil {
-		return nil, &models.FreeSeatsError{Message: "Invalid stationFromID"}
+		return nil, errors.New("Invalid stationFromID")
 	}
 
 	toStationId, err := strconv.Atoi(stationToID)
 	if err != nil {
-		return nil, &models.FreeSeatsError{Message: "Invalid stationToID"}
+		return nil, errors.New("Invalid stationToID")
 	}
 
 	bodyMap := map[string]interface{}{
@@ -143,38 +330,32 @@ func (c *TrainClient) fetchFreeSeats(routeId int, seatclass, stationFromID, stat
 
 	body, err := json.Marshal(bodyMap)
 	if err != nil {
-		return nil, &models.FreeSeatsError{Message: err.Error()}
-	}
-
-	resp, err := c.makeAPIRequest("POST", urlPath, body, map[string]string{"Content-Type": "application/json"})
-	if err != nil {
-		return nil, &models.FreeSeatsError{Message: "Failed to fetch free seats"}
+		return nil, err
 	}
-	defer resp.Body.Close()
 
 	var freeSeatsResponse models.FreeSeatsResponse
-	var freeSeatsError models.FreeSeatsError
-
-	err = json.NewDecoder(resp.Body).Decode(&freeSeatsResponse)
-	if err != nil {
-		err = json.NewDecoder(resp.Body).Decode(&freeSeatsError)
-		if err != nil {
-			return nil, &models.FreeSeatsError{Message: "Failed to fetch free seats"}
-		}
-		return nil, &freeSeatsError
+	if err := c.makeAPIRequest(ctx, "POST", urlPath, body, map[string]string{"Content-Type": "application/json"}, &freeSeatsResponse); err != nil {
+		return nil, err
 	}
 	return &freeSeatsResponse, nil
 }
 
-func (c *TrainClient) GetFreeSeats(routeID int, stationFromID, stationToID string) (models.FreeSeatsResponse, error) {
+func (c *TrainClient) GetFreeSeats(ctx context.Context, routeID int, stationFromID, stationToID string) (models.FreeSeatsResponse, error) {
+	key := fmt.Sprintf("freeSeats/%d/%s/%s", routeID, stationFromID, stationToID)
+	return coalesce(ctx, c.coalescer, key, c.shared(ctx), func(ctx context.Context) (models.FreeSeatsResponse, error) {
+		return c.getFreeSeats(ctx, routeID, stationFromID, stationToID)
+	})
+}
+
+func (c *TrainClient) getFreeSeats(ctx context.Context, routeID int, stationFromID, stationToID string) (models.FreeSeatsResponse, error) {
 	var combinedFreeSeatsResponse models.FreeSeatsResponse
 	seatClasses := []string{"C0", "C1", "C2"}
 
 	for _, seatClass := range seatClasses {
-		resp, err := c.fetchFreeSeats(routeID, seatClass, stationFromID, stationToID)
+		resp, err := c.fetchFreeSeats(ctx, routeID, seatClass, stationFromID, stationToID)
 		if err != nil {
-			c.logger.Error("Failed to fetch data", zap.String("error", err.Message))
-			return nil, errors.New(err.Message)
+			c.logger.Error("Failed to fetch data", zap.Error(err))
+			return nil, err
 		}
 		if resp != nil {
 			combinedFreeSeatsResponse = append(combinedFreeSeatsResponse, *resp...)
@@ -187,18 +368,18 @@ func (c *TrainClient) GetFreeSeats(routeID int, stationFromID, stationToID strin
 	return combinedFreeSeatsResponse, nil
 }
 
-func (c *TrainClient) FetchStops(routeID string) (*models.TimetableResponse, error) {
-	urlPath := fmt.Sprintf("/consts/timetables/%s", routeID)
+func (c *TrainClient) FetchStops(ctx context.Context, routeID string) (*models.TimetableResponse, error) {
+	return coalesce(ctx, c.coalescer, "stops/"+routeID, c.shared(ctx), func(ctx context.Context) (*models.TimetableResponse, error) {
+		return c.fetchStops(ctx, routeID)
+	})
+}
 
-	resp, err := c.makeAPIRequest("GET", urlPath, nil, nil)
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
+func (c *TrainClient) fetchStops(ctx context.Context, routeID string) (*models.TimetableResponse, error) {
+	urlPath := fmt.Sprintf("/consts/timetables/%s", routeID)
 
 	var timetable models.TimetableResponse
-	if err := json.NewDecoder(resp.Body).Decode(&timetable); err != nil {
-		fmt.Printf("error in fetching stops %+v\n", err)
+	if err := c.makeAPIRequest(ctx, "GET", urlPath, nil, nil, &timetable); err != nil {
+		return nil, err
 	}
 
 	if len(timetable.Stations) == 0 {
@@ -207,24 +388,18 @@ func (c *TrainClient) FetchStops(routeID string) (*models.TimetableResponse, err
 	return &timetable, nil
 }
 
-func (c *TrainClient) GetRouteDetails(routeID int, fromStationID, toStationID string) (*models.RouteDetails, error) {
-	urlPath := fmt.Sprintf("/routes/%d/simple?fromStationId=%s&toStationId=%s", routeID, fromStationID, toStationID)
-
-	req, err := http.NewRequest("GET", urlPath, nil)
-	if err != nil {
-		return nil, err
-	}
-
-	req.Header.Set("X-Currency", "CZK")
+func (c *TrainClient) GetRouteDetails(ctx context.Context, routeID int, fromStationID, toStationID string) (*models.RouteDetails, error) {
+	key := fmt.Sprintf("routeDetails/%d/%s/%s", routeID, fromStationID, toStationID)
+	return coalesce(ctx, c.coalescer, key, c.shared(ctx), func(ctx context.Context) (*models.RouteDetails, error) {
+		return c.getRouteDetails(ctx, routeID, fromStationID, toStationID)
+	})
+}
 
-	resp, err := c.makeAPIRequest("GET", urlPath, nil, map[string]string{"X-Currency": "CZK"})
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
+func (c *TrainClient) getRouteDetails(ctx context.Context, routeID int, fromStationID, toStationID string) (*models.RouteDetails, error) {
+	urlPath := fmt.Sprintf("/routes/%d/simple?fromStationId=%s&toStationId=%s", routeID, fromStationID, toStationID)
 
 	var apiResponse models.RouteDetailsResponse
-	if err := json.NewDecoder(resp.Body).Decode(&apiResponse); err != nil {
+	if err := c.makeAPIRequest(ctx, "GET", urlPath, nil, map[string]string{"X-Currency": "CZK"}, &apiResponse); err != nil {
 		return nil, err
 	}
 
@@ -243,3 +418,13 @@ func (c *TrainClient) GetRouteDetails(routeID int, fromStationID, toStationID st
 		ArrivalTime:       apiResponse.ArrivalTime,
 	}, nil
 }
+
+// RegisterClientHooks cancels the requests still running on stop.
+func RegisterClientHooks(lc fx.Lifecycle, client *TrainClient) {
+	lc.Append(fx.Hook{
+		OnStop: func(context.Context) error {
+			client.cancel()
+			return nil
+		},
+	})
+}
@@ -0,0 +1,40 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/bxxf/regiojet-watchdog/internal/config"
+	"go.uber.org/zap"
+)
+
+func TestMakeAPIRequestRetries(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests == 1 {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("not json"))
+	}))
+	defer server.Close()
+
+	c := NewTrainClient(zap.NewNop(), config.Config{
+		RegioJetAPIURL:       server.URL,
+		UpstreamTimeout:      10 * time.Second,
+		UpstreamMaxRetries:   3,
+		UpstreamRetryBackoff: time.Millisecond,
+	})
+	var out struct{}
+	err := c.makeAPIRequest(context.Background(), "GET", "/", nil, nil, &out)
+
+	// the server error is retried, the invalid response is not
+	if !errors.Is(err, ErrDecode) || requests != 2 {
+		t.Errorf("got %v after %d requests, want an invalid response after 2", err, requests)
+	}
+}
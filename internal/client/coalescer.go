@@ -0,0 +1,147 @@
+package client
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// coalescer makes identical upstream queries once. Concurrent calls with the
+// same key share a single call, and successful results are reused for ttl, so
+// watchdogs of the same route checked in the same tick share their queries.
+type coalescer struct {
+	ttl time.Duration
+
+	mu        sync.Mutex
+	entries   map[string]cacheEntry
+	calls     map[string]*call
+	lastSweep time.Time
+}
+
+type cacheEntry struct {
+	value     interface{}
+	expiresAt time.Time
+}
+
+// call is a query in flight, shared by its waiters.
+type call struct {
+	done    chan struct{}
+	value   interface{}
+	err     error
+	waiters int
+	cancel  context.CancelFunc
+}
+
+func newCoalescer(ttl time.Duration) *coalescer {
+	return &coalescer{
+		ttl:     ttl,
+		entries: make(map[string]cacheEntry),
+		calls:   make(map[string]*call),
+	}
+}
+
+// coalesce returns the cached result of key or calls fetch, sharing the call
+// with concurrent callers of the same key. Callers must not modify the result.
+//
+// The shared call is not bound to ctx, since other callers may still wait for
+// it, but runs on a context derived from shared. A caller whose ctx is done
+// stops waiting, and when the last one has stopped, the call is cancelled, so
+// it does not wait for the rate limiter or retry for nobody.
+func coalesce[T any](ctx context.Context, c *coalescer, key string, shared context.Context, fetch func(ctx context.Context) (T, error)) (T, error) {
+	var zero T
+	if value, ok := c.get(key); ok {
+		return value.(T), nil
+	}
+
+	c.mu.Lock()
+	current, ok := c.calls[key]
+	if !ok {
+		callCtx, cancel := context.WithCancel(shared)
+		current = &call{done: make(chan struct{}), cancel: cancel}
+		c.calls[key] = current
+		go c.run(key, current, func() (interface{}, error) {
+			return fetch(callCtx)
+		})
+	}
+	current.waiters++
+	c.mu.Unlock()
+
+	select {
+	case <-current.done:
+		if current.err != nil {
+			return zero, current.err
+		}
+		return current.value.(T), nil
+	case <-ctx.Done():
+		c.leave(key, current)
+		return zero, ctx.Err()
+	}
+}
+
+// run makes the call and hands its result to the waiters.
+func (c *coalescer) run(key string, current *call, fetch func() (interface{}, error)) {
+	value, err := fetch()
+	if err == nil {
+		c.set(key, value)
+	}
+
+	c.mu.Lock()
+	if c.calls[key] == current {
+		delete(c.calls, key)
+	}
+	c.mu.Unlock()
+
+	current.value, current.err = value, err
+	current.cancel()
+	close(current.done)
+}
+
+// leave stops a waiter waiting for the call, cancelling the call if nobody
+// else waits for it. A later caller of the key makes a new call.
+func (c *coalescer) leave(key string, current *call) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	current.waiters--
+	if current.waiters > 0 {
+		return
+	}
+	current.cancel()
+	if c.calls[key] == current {
+		delete(c.calls, key)
+	}
+}
+
+func (c *coalescer) get(key string) (interface{}, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[key]
+	if !ok || !time.Now().Before(entry.expiresAt) {
+		return nil, false
+	}
+	return entry.value, true
+}
+
+func (c *coalescer) set(key string, value interface{}) {
+	if c.ttl <= 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	c.entries[key] = cacheEntry{value: value, expiresAt: now.Add(c.ttl)}
+
+	// drop expired entries now and then, so the cache does not grow with
+	// every route ever queried
+	if now.Sub(c.lastSweep) >= c.ttl {
+		for key, entry := range c.entries {
+			if !now.Before(entry.expiresAt) {
+				delete(c.entries, key)
+			}
+		}
+		c.lastSweep = now
+	}
+}
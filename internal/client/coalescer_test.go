@@ -0,0 +1,75 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// waiters returns how many callers wait for the call of key.
+func waiters(c *coalescer, key string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if current, ok := c.calls[key]; ok {
+		return current.waiters
+	}
+	return 0
+}
+
+func TestSharedCallIsCancelledWithItsLastWaiter(t *testing.T) {
+	c := newCoalescer(time.Minute)
+	started := make(chan struct{})
+	cancelled := make(chan struct{})
+	fetch := func(ctx context.Context) (int, error) {
+		close(started)
+		<-ctx.Done()
+		close(cancelled)
+		return 0, ctx.Err()
+	}
+
+	first, cancelFirst := context.WithCancel(context.Background())
+	second, cancelSecond := context.WithCancel(context.Background())
+	results := make(chan error, 2)
+	go func() {
+		_, err := coalesce(first, c, "key", context.Background(), fetch)
+		results <- err
+	}()
+	<-started
+	go func() {
+		_, err := coalesce(second, c, "key", context.Background(), func(context.Context) (int, error) {
+			t.Error("made a second call instead of sharing the first")
+			return 0, nil
+		})
+		results <- err
+	}()
+	for waiters(c, "key") < 2 {
+		time.Sleep(time.Millisecond)
+	}
+
+	cancelFirst()
+	if err := <-results; !errors.Is(err, context.Canceled) {
+		t.Errorf("got %v for the first caller, want it to stop waiting", err)
+	}
+	select {
+	case <-cancelled:
+		t.Fatal("the call was cancelled while the second caller waits for it")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	cancelSecond()
+	<-results
+	select {
+	case <-cancelled:
+	case <-time.After(time.Second):
+		t.Fatal("the call was not cancelled when nobody waits for it")
+	}
+
+	// a later caller makes a new call
+	value, err := coalesce(context.Background(), c, "key", context.Background(), func(context.Context) (int, error) {
+		return 42, nil
+	})
+	if err != nil || value != 42 {
+		t.Errorf("got %d, %v, want a new call", value, err)
+	}
+}
@@ -0,0 +1,91 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/bxxf/regiojet-watchdog/internal/retryafter"
+)
+
+// Kinds of UpstreamError, to be checked with errors.Is.
+var (
+	ErrNotFound    = errors.New("not found")
+	ErrRateLimited = errors.New("rate limited")
+	ErrServerError = errors.New("server error")
+	ErrRejected    = errors.New("request rejected")
+	ErrDecode      = errors.New("invalid response")
+)
+
+// ErrCircuitOpen is returned without making the request while the RegioJet
+// API is considered broken.
+var ErrCircuitOpen = errors.New("RegioJet API is unavailable, circuit breaker is open")
+
+// UpstreamError is returned when the RegioJet API answers with an error or
+// with a response that cannot be decoded.
+type UpstreamError struct {
+	Kind       error
+	StatusCode int
+	// RetryAfter is how long the API asked to wait before retrying, zero if
+	// it did not say.
+	RetryAfter time.Duration
+	Message    string
+}
+
+// newStatusError returns the error of a response with an unexpected status
+// code, with the message of the API if it sent one.
+func newStatusError(resp *http.Response, message string) *UpstreamError {
+	kind := ErrRejected
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		kind = ErrNotFound
+	case resp.StatusCode == http.StatusTooManyRequests:
+		kind = ErrRateLimited
+	case resp.StatusCode >= 500:
+		kind = ErrServerError
+	}
+	if message == "" {
+		message = http.StatusText(resp.StatusCode)
+	}
+
+	return &UpstreamError{
+		Kind:       kind,
+		StatusCode: resp.StatusCode,
+		RetryAfter: retryafter.Parse(resp.Header, time.Now()),
+		Message:    message,
+	}
+}
+
+func (e *UpstreamError) Error() string {
+	if e.Kind == ErrDecode {
+		return fmt.Sprintf("RegioJet API %s: %s", e.Kind, e.Message)
+	}
+	return fmt.Sprintf("RegioJet API %s (status code %d): %s", e.Kind, e.StatusCode, e.Message)
+}
+
+func (e *UpstreamError) Unwrap() error {
+	return e.Kind
+}
+
+// retryable reports whether the request may succeed when it is made again:
+// server errors, rate limiting and failed or dropped connections. Anything
+// else, such as a request that cannot be made, fails the same way again.
+func retryable(err error) bool {
+	var upstreamErr *UpstreamError
+	if errors.As(err, &upstreamErr) {
+		return upstreamErr.Kind == ErrServerError || upstreamErr.Kind == ErrRateLimited
+	}
+
+	// every error of the HTTP client is a url.Error, which is a net.Error
+	// whatever its cause
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		err = urlErr.Err
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF)
+}
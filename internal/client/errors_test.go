@@ -0,0 +1,34 @@
+package client
+
+import (
+	"errors"
+	"io"
+	"net"
+	"net/url"
+	"syscall"
+	"testing"
+)
+
+func TestRetryable(t *testing.T) {
+	cases := []struct {
+		name      string
+		err       error
+		retryable bool
+	}{
+		{"server error", &UpstreamError{Kind: ErrServerError, StatusCode: 503}, true},
+		{"rate limited", &UpstreamError{Kind: ErrRateLimited, StatusCode: 429}, true},
+		{"not found", &UpstreamError{Kind: ErrNotFound, StatusCode: 404}, false},
+		{"invalid response", &UpstreamError{Kind: ErrDecode, StatusCode: 200}, false},
+		{"circuit open", ErrCircuitOpen, false},
+		{"connection refused", &url.Error{Op: "Get", URL: "http://localhost", Err: &net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}}, true},
+		{"connection closed", &url.Error{Op: "Get", URL: "http://localhost", Err: io.EOF}, true},
+		{"truncated body", io.ErrUnexpectedEOF, true},
+		{"invalid URL", &url.Error{Op: "Get", URL: "localhost", Err: errors.New(`unsupported protocol scheme ""`)}, false},
+		{"invalid request", errors.New("Invalid stationFromID"), false},
+	}
+	for _, c := range cases {
+		if got := retryable(c.err); got != c.retryable {
+			t.Errorf("%s: got retryable %v, want %v", c.name, got, c.retryable)
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package client
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Priority orders the requests waiting for the rate limiter.
+type Priority int
+
+const (
+	// PriorityBackground is the priority of requests without one, e.g. those
+	// of watchdog checks.
+	PriorityBackground Priority = iota
+	// PriorityInteractive is for requests a user is waiting for.
+	PriorityInteractive
+)
+
+type priorityKey struct{}
+
+// WithPriority returns a context whose requests to the RegioJet API wait for
+// the rate limiter with the given priority.
+func WithPriority(ctx context.Context, priority Priority) context.Context {
+	return context.WithValue(ctx, priorityKey{}, priority)
+}
+
+func priorityOf(ctx context.Context) Priority {
+	priority, _ := ctx.Value(priorityKey{}).(Priority)
+	if priority < PriorityBackground || priority > PriorityInteractive {
+		return PriorityBackground
+	}
+	return priority
+}
+
+// limiter is a token bucket shared by all requests to the RegioJet API. It
+// holds up to burst tokens and earns one every interval. Requests that have to
+// wait are queued, those of higher priority ahead of the rest.
+type limiter struct {
+	mu       sync.Mutex
+	interval time.Duration
+	burst    float64
+	tokens   float64
+	last     time.Time
+	queues   [PriorityInteractive + 1][]chan struct{}
+	timer    *time.Timer
+}
+
+// newLimiter returns a limiter for perMinute requests per minute with bursts
+// of up to burst requests, or nil for no limit.
+func newLimiter(perMinute, burst int) *limiter {
+	if perMinute <= 0 {
+		return nil
+	}
+	if burst < 1 {
+		burst = 1
+	}
+	return &limiter{
+		interval: time.Minute / time.Duration(perMinute),
+		burst:    float64(burst),
+		tokens:   float64(burst),
+		last:     time.Now(),
+	}
+}
+
+// wait blocks until the request may be made or ctx is done.
+func (l *limiter) wait(ctx context.Context) error {
+	if l == nil {
+		return nil
+	}
+
+	l.mu.Lock()
+	l.refill()
+	if l.tokens >= 1 && l.queued() == 0 {
+		l.tokens--
+		l.mu.Unlock()
+		return nil
+	}
+
+	priority := priorityOf(ctx)
+	ready := make(chan struct{})
+	l.queues[priority] = append(l.queues[priority], ready)
+	l.schedule()
+	l.mu.Unlock()
+
+	select {
+	case <-ready:
+		return nil
+	case <-ctx.Done():
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	select {
+	case <-ready:
+		// granted meanwhile, the token goes to the next request
+		l.tokens++
+		l.grant()
+	default:
+		queue := l.queues[priority]
+		for i, waiting := range queue {
+			if waiting == ready {
+				l.queues[priority] = append(queue[:i], queue[i+1:]...)
+				break
+			}
+		}
+	}
+	return ctx.Err()
+}
+
+func (l *limiter) refill() {
+	now := time.Now()
+	l.tokens += float64(now.Sub(l.last)) / float64(l.interval)
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+	l.last = now
+}
+
+func (l *limiter) queued() int {
+	n := 0
+	for _, queue := range l.queues {
+		n += len(queue)
+	}
+	return n
+}
+
+// grant hands the available tokens to the queued requests, highest priority
+// first.
+func (l *limiter) grant() {
+	for priority := len(l.queues) - 1; priority >= 0; priority-- {
+		for l.tokens >= 1 && len(l.queues[priority]) > 0 {
+			l.tokens--
+			close(l.queues[priority][0])
+			l.queues[priority] = l.queues[priority][1:]
+		}
+	}
+	l.schedule()
+}
+
+// schedule wakes the limiter up when the next token is earned, if requests
+// are waiting for it.
+func (l *limiter) schedule() {
+	if l.timer != nil || l.queued() == 0 {
+		return
+	}
+
+	delay := time.Duration((1 - l.tokens) * float64(l.interval))
+	l.timer = time.AfterFunc(delay, func() {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+
+		l.timer = nil
+		l.refill()
+		l.grant()
+	})
+}
@@ -1,15 +1,112 @@
 package config
 
 import (
+	"fmt"
 	"log"
 	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/bxxf/regiojet-watchdog/internal/models"
+	"github.com/google/uuid"
 	"github.com/joho/godotenv"
 )
 
+// defaultPollCurve checks watchdogs more often the closer the departure is,
+// as seats are mostly freed up by cancellations in the last hours.
+const defaultPollCurve = "7d=15m,1d=5m,6h=2m,2h=1m,0=30s"
+
+// PollStep is a point of the polling curve. Watchdogs that depart in at least
+// Before are checked every Interval.
+type PollStep struct {
+	Before   time.Duration
+	Interval time.Duration
+}
+
 type Config struct {
-	RedisURL string
-	Port     string
+	StorageBackend string
+	StoragePath    string
+	RedisURL       string
+	Port           string
+	// RegioJetAPIURL is the base URL of the RegioJet API, e.g. of a fake one
+	// for developing offline.
+	RegioJetAPIURL string
+	// UpstreamRecording is "record" to save the RegioJet API traffic to
+	// UpstreamRecordingsDir, or "replay" to answer requests from it.
+	UpstreamRecording     string
+	UpstreamRecordingsDir string
+	// RenotifyInterval is how often an unchanged availability is notified
+	// again, zero meaning never.
+	RenotifyInterval time.Duration
+	// PollCurve is sorted by Before, latest departures first. Without a curve,
+	// watchdogs are checked every CheckInterval.
+	PollCurve []PollStep
+	// CheckInterval is how often a watchdog is checked unless it sets its own
+	// interval or the poll curve applies.
+	CheckInterval time.Duration
+	// UpstreamRequestsPerMinute caps the requests to the RegioJet API, zero
+	// meaning no limit.
+	UpstreamRequestsPerMinute int
+	// UpstreamBurst is how many requests to the RegioJet API may be made at
+	// once before they are limited to UpstreamRequestsPerMinute.
+	UpstreamBurst int
+	// UpstreamCacheTTL is how long results of RegioJet API queries are reused,
+	// zero meaning only concurrent identical queries are shared.
+	UpstreamCacheTTL time.Duration
+	// UpstreamTimeout bounds a single request to the RegioJet API, zero
+	// meaning no limit.
+	UpstreamTimeout time.Duration
+	// UpstreamMaxRetries is how often a failed request to the RegioJet API is
+	// retried.
+	UpstreamMaxRetries int
+	// UpstreamRetryBackoff is the delay before the first retry, doubled for
+	// every following one.
+	UpstreamRetryBackoff time.Duration
+	// UpstreamBreakerThreshold is how many requests to the RegioJet API fail
+	// in a row before requests are stopped for UpstreamBreakerCooldown, zero
+	// meaning never.
+	UpstreamBreakerThreshold int
+	UpstreamBreakerCooldown  time.Duration
+	// CheckWorkers is how many watchdogs are checked concurrently.
+	CheckWorkers int
+	// CheckTimeout bounds a single check of a watchdog, zero meaning no limit.
+	// A search for alternatives makes at most its share, among the
+	// CheckWorkers, of the requests UpstreamRequestsPerMinute allows within it.
+	CheckTimeout time.Duration
+	// SegmentationWorkers is how many segments of a route are checked
+	// concurrently when searching alternatives.
+	SegmentationWorkers int
+	// AlternativesObjective and AlternativesLimit rank the alternative routes
+	// of watchdogs that do not choose their own ranking.
+	AlternativesObjective string
+	AlternativesLimit     int
+	// MinTransferTime is the least time between arriving at a station and
+	// departing with another train in alternative connections.
+	MinTransferTime time.Duration
+	// InstanceID identifies this instance as the owner of watchdog leases.
+	InstanceID string
+	// LeaseTTL is how long a watchdog being checked is owned by an instance
+	// without renewing the lease.
+	LeaseTTL time.Duration
+	// NotifyMaxAttempts is how often a notification is tried before it is
+	// moved to the dead-letter list.
+	NotifyMaxAttempts int
+	// NotifyRetryBackoff is the delay before the first retry, doubled for
+	// every following one.
+	NotifyRetryBackoff time.Duration
+
+	TelegramBotToken string
+	TelegramAPIURL   string
+
+	SMTPHost     string
+	SMTPPort     string
+	SMTPUsername string
+	SMTPPassword string
+	SMTPFrom     string
+	// SMTPTLS is "starttls", "tls" (implicit TLS) or "none".
+	SMTPTLS string
 }
 
 func LoadConfig() Config {
@@ -24,18 +121,229 @@ func LoadConfig() Config {
 		}
 	}
 
+	storageBackend := os.Getenv("STORAGE_BACKEND")
+	if storageBackend == "" {
+		storageBackend = "redis"
+	}
+
 	redisURL := os.Getenv("REDIS_URL")
-	if redisURL == "" {
+	if storageBackend == "redis" && redisURL == "" {
 		log.Fatal("REDIS_URL must be set")
 	}
 
+	storagePath := os.Getenv("STORAGE_PATH")
+	if storagePath == "" {
+		storagePath = "watchdog.db"
+	}
+
 	port := os.Getenv("PORT")
 	if port == "" {
 		port = "7900"
 	}
 
+	regioJetAPIURL := os.Getenv("REGIOJET_API_URL")
+	if regioJetAPIURL == "" {
+		regioJetAPIURL = "https://brn-ybus-pubapi.sa.cz/restapi"
+	}
+
+	upstreamRecording := os.Getenv("UPSTREAM_RECORDING")
+	if upstreamRecording != "" && upstreamRecording != "record" && upstreamRecording != "replay" {
+		log.Fatal("UPSTREAM_RECORDING must be record or replay")
+	}
+
+	upstreamRecordingsDir := os.Getenv("UPSTREAM_RECORDINGS_DIR")
+	if upstreamRecordingsDir == "" {
+		upstreamRecordingsDir = "recordings"
+	}
+
+	telegramAPIURL := os.Getenv("TELEGRAM_API_URL")
+	if telegramAPIURL == "" {
+		telegramAPIURL = "https://api.telegram.org"
+	}
+
+	smtpTLS := os.Getenv("SMTP_TLS")
+	if smtpTLS == "" {
+		smtpTLS = "starttls"
+	}
+	if smtpTLS != "starttls" && smtpTLS != "tls" && smtpTLS != "none" {
+		log.Fatal("SMTP_TLS must be one of starttls, tls or none")
+	}
+
+	smtpPort := os.Getenv("SMTP_PORT")
+	if smtpPort == "" {
+		if smtpTLS == "tls" {
+			smtpPort = "465"
+		} else {
+			smtpPort = "587"
+		}
+	}
+
+	checkInterval := durationEnv("CHECK_INTERVAL", time.Minute)
+	if checkInterval == 0 {
+		log.Fatal("CHECK_INTERVAL must be positive")
+	}
+
+	pollCurve := os.Getenv("POLL_CURVE")
+	if pollCurve == "" {
+		pollCurve = defaultPollCurve
+	}
+	var curve []PollStep
+	if pollCurve != "off" {
+		var err error
+		curve, err = parsePollCurve(pollCurve)
+		if err != nil {
+			log.Fatalf("Invalid POLL_CURVE: %v", err)
+		}
+	}
+
+	alternativesObjective := os.Getenv("ALTERNATIVES_OBJECTIVE")
+	switch alternativesObjective {
+	case "":
+		alternativesObjective = models.ObjectiveChanges
+	case models.ObjectiveChanges, models.ObjectivePrice, models.ObjectiveSeats, models.ObjectiveWeighted:
+	default:
+		log.Fatal("ALTERNATIVES_OBJECTIVE must be one of changes, price, seats or weighted")
+	}
+
+	alternativesLimit := intEnv("ALTERNATIVES_LIMIT", 5)
+	if alternativesLimit > models.MaxAlternatives {
+		log.Fatalf("ALTERNATIVES_LIMIT must be at most %d", models.MaxAlternatives)
+	}
+
+	instanceID := os.Getenv("INSTANCE_ID")
+	if instanceID == "" {
+		hostname, _ := os.Hostname()
+		instanceID = hostname + "-" + uuid.New().String()
+	}
+
+	leaseTTL := durationEnv("LEASE_TTL", time.Minute)
+	if leaseTTL < time.Second {
+		log.Fatal("LEASE_TTL must be at least 1s")
+	}
+
 	return Config{
-		RedisURL: redisURL,
-		Port:     port,
+		StorageBackend: storageBackend,
+		StoragePath:    storagePath,
+		RedisURL:       redisURL,
+		Port:           port,
+		RegioJetAPIURL: strings.TrimSuffix(regioJetAPIURL, "/"),
+
+		UpstreamRecording:     upstreamRecording,
+		UpstreamRecordingsDir: upstreamRecordingsDir,
+
+		UpstreamRequestsPerMinute: countEnv("UPSTREAM_REQUESTS_PER_MINUTE", 120),
+		UpstreamBurst:             intEnv("UPSTREAM_BURST", 10),
+		UpstreamCacheTTL:          durationEnv("UPSTREAM_CACHE_TTL", 15*time.Second),
+		UpstreamTimeout:           durationEnv("UPSTREAM_TIMEOUT", 30*time.Second),
+		UpstreamMaxRetries:        countEnv("UPSTREAM_MAX_RETRIES", 2),
+		UpstreamRetryBackoff:      durationEnv("UPSTREAM_RETRY_BACKOFF", 500*time.Millisecond),
+		UpstreamBreakerThreshold:  countEnv("UPSTREAM_BREAKER_THRESHOLD", 5),
+		UpstreamBreakerCooldown:   durationEnv("UPSTREAM_BREAKER_COOLDOWN", time.Minute),
+
+		RenotifyInterval:    durationEnv("RENOTIFY_INTERVAL", 0),
+		PollCurve:           curve,
+		CheckInterval:       checkInterval,
+		CheckWorkers:        intEnv("CHECK_WORKERS", 4),
+		CheckTimeout:        durationEnv("CHECK_TIMEOUT", 2*time.Minute),
+		SegmentationWorkers: intEnv("SEGMENTATION_WORKERS", 4),
+		InstanceID:          instanceID,
+		LeaseTTL:            leaseTTL,
+		NotifyMaxAttempts:   intEnv("NOTIFY_MAX_ATTEMPTS", 5),
+		NotifyRetryBackoff:  durationEnv("NOTIFY_RETRY_BACKOFF", 30*time.Second),
+
+		AlternativesObjective: alternativesObjective,
+		AlternativesLimit:     alternativesLimit,
+		MinTransferTime:       durationEnv("MIN_TRANSFER_TIME", 5*time.Minute),
+
+		TelegramBotToken: os.Getenv("TELEGRAM_BOT_TOKEN"),
+		TelegramAPIURL:   telegramAPIURL,
+
+		SMTPHost:     os.Getenv("SMTP_HOST"),
+		SMTPPort:     smtpPort,
+		SMTPUsername: os.Getenv("SMTP_USERNAME"),
+		SMTPPassword: os.Getenv("SMTP_PASSWORD"),
+		SMTPFrom:     os.Getenv("SMTP_FROM"),
+		SMTPTLS:      smtpTLS,
+	}
+}
+
+// durationEnv parses the environment variable as a duration such as "30s" or
+// "15m", returning fallback when it is not set.
+func durationEnv(name string, fallback time.Duration) time.Duration {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback
+	}
+
+	duration, err := time.ParseDuration(value)
+	if err != nil || duration < 0 {
+		log.Fatalf("%s must be a non-negative duration, e.g. 30s or 15m", name)
+	}
+	return duration
+}
+
+// intEnv parses the environment variable as a positive integer, returning
+// fallback when it is not set.
+func intEnv(name string, fallback int) int {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback
+	}
+
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 1 {
+		log.Fatalf("%s must be a positive integer", name)
+	}
+	return n
+}
+
+// countEnv parses the environment variable as a non-negative integer,
+// returning fallback when it is not set.
+func countEnv(name string, fallback int) int {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback
+	}
+
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		log.Fatalf("%s must be a non-negative integer", name)
+	}
+	return n
+}
+
+// parsePollCurve parses comma separated "<before>=<interval>" steps such as
+// "7d=15m,2h=1m,0=30s", where before may also be given in days.
+func parsePollCurve(value string) ([]PollStep, error) {
+	var curve []PollStep
+	for _, step := range strings.Split(value, ",") {
+		before, interval, ok := strings.Cut(strings.TrimSpace(step), "=")
+		if !ok {
+			return nil, fmt.Errorf("step %q is not <before>=<interval>", step)
+		}
+
+		var s PollStep
+		var err error
+		if s.Before, err = parseDays(before); err != nil || s.Before < 0 {
+			return nil, fmt.Errorf("invalid time before departure %q", before)
+		}
+		if s.Interval, err = time.ParseDuration(interval); err != nil || s.Interval <= 0 {
+			return nil, fmt.Errorf("invalid interval %q", interval)
+		}
+		curve = append(curve, s)
+	}
+
+	sort.Slice(curve, func(i, j int) bool {
+		return curve[i].Before > curve[j].Before
+	})
+	return curve, nil
+}
+
+// parseDays parses a duration that may also be given in whole days, e.g. "7d".
+func parseDays(value string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(value, "d"); ok {
+		n, err := strconv.Atoi(days)
+		return time.Duration(n) * 24 * time.Hour, err
 	}
+	return time.ParseDuration(value)
 }
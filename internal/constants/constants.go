@@ -8,17 +8,26 @@ import (
 	"net/http"
 	"strconv"
 
+	"github.com/bxxf/regiojet-watchdog/internal/config"
+	"github.com/bxxf/regiojet-watchdog/internal/recorder"
 	"go.uber.org/fx"
 	"go.uber.org/zap"
 )
 
 type ConstantsClient struct {
-	logger *zap.Logger
+	logger  *zap.Logger
+	client  *http.Client
+	baseURL string
 }
 
-func NewConstantsClient(logger *zap.Logger) *ConstantsClient {
+func NewConstantsClient(logger *zap.Logger, config config.Config) *ConstantsClient {
 	return &ConstantsClient{
 		logger: logger,
+		client: &http.Client{
+			Timeout:   config.UpstreamTimeout,
+			Transport: recorder.NewTransport(config.UpstreamRecording, config.UpstreamRecordingsDir, http.DefaultTransport),
+		},
+		baseURL: config.RegioJetAPIURL,
 	}
 }
 
@@ -36,8 +45,13 @@ type Station struct {
 	StationTypes []string `json:"stationsTypes"`
 }
 
-func (c *ConstantsClient) FetchConstants() (map[string]string, error) {
-	resp, err := http.Get("https://brn-ybus-pubapi.sa.cz/restapi/consts/locations")
+func (c *ConstantsClient) FetchConstants(ctx context.Context) (map[string]string, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", c.baseURL+"/consts/locations", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.client.Do(req)
 	if err != nil {
 		return nil, err
 	}
@@ -77,7 +91,7 @@ func (c *ConstantsClient) FetchConstants() (map[string]string, error) {
 func RegisterConstantsHooks(lc fx.Lifecycle, service *ConstantsClient) {
 	lc.Append(fx.Hook{
 		OnStart: func(context.Context) error {
-			go service.FetchConstants()
+			go service.FetchConstants(context.Background())
 			return nil
 		},
 		OnStop: nil,
@@ -0,0 +1,495 @@
+package database
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/bxxf/regiojet-watchdog/internal/models"
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	watchdogsBucket     = []byte("watchdogs")
+	nextChecksBucket    = []byte("nextchecks")
+	leasesBucket        = []byte("leases")
+	notificationsBucket = []byte("notifications")
+	deadLettersBucket   = []byte("deadletters")
+	// invalidBucket keeps queued notifications that cannot be decoded for
+	// inspection, out of the queue.
+	invalidBucket = []byte("invalid")
+)
+
+// BoltStore keeps watchdogs and queued notifications in a single bbolt file,
+// so small deployments can run without Redis.
+type BoltStore struct {
+	db *bolt.DB
+}
+
+func NewBoltStore(path string) (*BoltStore, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %v", path, err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, bucket := range [][]byte{watchdogsBucket, nextChecksBucket, leasesBucket, notificationsBucket, deadLettersBucket, invalidBucket} {
+			if _, err := tx.CreateBucketIfNotExists(bucket); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &BoltStore{
+		db: db,
+	}, nil
+}
+
+func (s *BoltStore) SaveWatchdog(ctx context.Context, watchdog models.Watchdog) error {
+	if _, err := watchdogTTL(watchdog); err != nil {
+		return err
+	}
+
+	watchdog.Version = models.WatchdogVersion
+	value, err := json.Marshal(watchdog)
+	if err != nil {
+		return err
+	}
+
+	return s.db.Update(func(tx *bolt.Tx) error {
+		if err := tx.Bucket(watchdogsBucket).Put([]byte(watchdog.ID), value); err != nil {
+			return err
+		}
+
+		nextChecks := tx.Bucket(nextChecksBucket)
+		if nextChecks.Get([]byte(watchdog.ID)) != nil {
+			return nil
+		}
+		return nextChecks.Put([]byte(watchdog.ID), encodeTime(time.Now()))
+	})
+}
+
+func (s *BoltStore) GetWatchdog(ctx context.Context, id string) (*models.Watchdog, error) {
+	var watchdog *models.Watchdog
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		value := tx.Bucket(watchdogsBucket).Get([]byte(id))
+		if value == nil {
+			return ErrWatchdogNotFound
+		}
+
+		var err error
+		watchdog, err = decodeWatchdog(id, value)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if isExpired(*watchdog, time.Now()) {
+		s.DeleteWatchdog(ctx, id)
+		return nil, ErrWatchdogNotFound
+	}
+	return watchdog, nil
+}
+
+func (s *BoltStore) ListWatchdogs(ctx context.Context) ([]models.Watchdog, error) {
+	watchdogs := []models.Watchdog{}
+	var expired []string
+
+	now := time.Now()
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(watchdogsBucket).ForEach(func(key, value []byte) error {
+			watchdog, err := decodeWatchdog(string(key), value)
+			if err != nil {
+				log.Println("Skipping watchdog:", err)
+				return nil
+			}
+			if isExpired(*watchdog, now) {
+				expired = append(expired, watchdog.ID)
+				return nil
+			}
+			watchdogs = append(watchdogs, *watchdog)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, id := range expired {
+		s.DeleteWatchdog(ctx, id)
+	}
+	return watchdogs, nil
+}
+
+func (s *BoltStore) DeleteWatchdog(ctx context.Context, id string) error {
+	found := false
+
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(watchdogsBucket)
+		value := bucket.Get([]byte(id))
+		if value == nil {
+			return nil
+		}
+
+		watchdog, err := decodeWatchdog(id, value)
+		found = err != nil || !isExpired(*watchdog, time.Now())
+		if err := tx.Bucket(nextChecksBucket).Delete([]byte(id)); err != nil {
+			return err
+		}
+		if err := tx.Bucket(leasesBucket).Delete([]byte(id)); err != nil {
+			return err
+		}
+		return bucket.Delete([]byte(id))
+	})
+	if err != nil {
+		return err
+	}
+	if !found {
+		return ErrWatchdogNotFound
+	}
+	return nil
+}
+
+func (s *BoltStore) RecordCheck(ctx context.Context, id string, snapshot models.AvailabilitySnapshot, notifiedAt *time.Time) error {
+	return s.UpdateWatchdog(ctx, id, func(watchdog *models.Watchdog) error {
+		recordCheck(watchdog, snapshot, notifiedAt)
+		return nil
+	})
+}
+
+func (s *BoltStore) UpdateWatchdog(ctx context.Context, id string, update func(watchdog *models.Watchdog) error) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(watchdogsBucket)
+		value := bucket.Get([]byte(id))
+		if value == nil {
+			return ErrWatchdogNotFound
+		}
+
+		watchdog, err := decodeWatchdog(id, value)
+		if err != nil {
+			return err
+		}
+		if isExpired(*watchdog, time.Now()) {
+			return ErrWatchdogNotFound
+		}
+		if err := update(watchdog); err != nil {
+			return err
+		}
+		if _, err := watchdogTTL(*watchdog); err != nil {
+			return err
+		}
+
+		watchdog.Version = models.WatchdogVersion
+		value, err = json.Marshal(watchdog)
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte(id), value)
+	})
+}
+
+func (s *BoltStore) ScheduleCheck(ctx context.Context, id string, at time.Time) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		nextChecks := tx.Bucket(nextChecksBucket)
+		if nextChecks.Get([]byte(id)) == nil {
+			return nil
+		}
+		return nextChecks.Put([]byte(id), encodeTime(at))
+	})
+}
+
+func (s *BoltStore) ClaimDueWatchdogs(ctx context.Context, now time.Time, limit int, claimTimeout time.Duration) ([]string, error) {
+	type dueCheck struct {
+		id string
+		at time.Time
+	}
+	var due []dueCheck
+
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		watchdogs := tx.Bucket(watchdogsBucket)
+		nextChecks := tx.Bucket(nextChecksBucket)
+
+		var removed [][]byte
+		err := nextChecks.ForEach(func(key, value []byte) error {
+			at := decodeTime(value)
+			if at.After(now) {
+				return nil
+			}
+
+			record := watchdogs.Get(key)
+			if record == nil {
+				removed = append(removed, key)
+				return nil
+			}
+			watchdog, err := decodeWatchdog(string(key), record)
+			if err == nil && isExpired(*watchdog, now) {
+				removed = append(removed, key)
+				return nil
+			}
+			due = append(due, dueCheck{id: string(key), at: at})
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		// keys must not be changed while iterating the bucket
+		for _, key := range removed {
+			if err := watchdogs.Delete(key); err != nil {
+				return err
+			}
+			if err := nextChecks.Delete(key); err != nil {
+				return err
+			}
+		}
+
+		sort.Slice(due, func(i, j int) bool {
+			return due[i].at.Before(due[j].at)
+		})
+		if len(due) > limit {
+			due = due[:limit]
+		}
+		for _, check := range due {
+			if err := nextChecks.Put([]byte(check.id), encodeTime(now.Add(claimTimeout))); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]string, 0, len(due))
+	for _, check := range due {
+		ids = append(ids, check.id)
+	}
+	return ids, nil
+}
+
+func (s *BoltStore) AcquireLease(ctx context.Context, id, owner string, ttl time.Duration) (bool, error) {
+	return s.updateLease(id, func(current *lease, now time.Time) bool {
+		return current == nil || current.Owner == owner || !now.Before(current.ExpiresAt)
+	}, &lease{Owner: owner}, ttl)
+}
+
+func (s *BoltStore) RenewLease(ctx context.Context, id, owner string, ttl time.Duration) (bool, error) {
+	return s.updateLease(id, func(current *lease, now time.Time) bool {
+		return current != nil && current.Owner == owner && now.Before(current.ExpiresAt)
+	}, &lease{Owner: owner}, ttl)
+}
+
+func (s *BoltStore) ReleaseLease(ctx context.Context, id, owner string) error {
+	_, err := s.updateLease(id, func(current *lease, now time.Time) bool {
+		return current != nil && current.Owner == owner
+	}, nil, 0)
+	return err
+}
+
+// updateLease replaces the lease of the watchdog with next, or deletes it if
+// next is nil, when allowed returns true for the current lease.
+func (s *BoltStore) updateLease(id string, allowed func(current *lease, now time.Time) bool, next *lease, ttl time.Duration) (bool, error) {
+	updated := false
+
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(leasesBucket)
+		now := time.Now()
+
+		var current *lease
+		if value := bucket.Get([]byte(id)); value != nil {
+			current = &lease{}
+			if err := json.Unmarshal(value, current); err != nil {
+				return fmt.Errorf("failed to decode lease of watchdog %s: %v", id, err)
+			}
+		}
+		if !allowed(current, now) {
+			return nil
+		}
+		updated = true
+
+		if next == nil {
+			return bucket.Delete([]byte(id))
+		}
+		next.ExpiresAt = now.Add(ttl)
+		value, err := json.Marshal(next)
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte(id), value)
+	})
+	return updated, err
+}
+
+// encodeTime encodes the next checks of watchdogs as big endian unix
+// nanoseconds.
+func encodeTime(t time.Time) []byte {
+	value := make([]byte, 8)
+	binary.BigEndian.PutUint64(value, uint64(t.UnixNano()))
+	return value
+}
+
+func decodeTime(value []byte) time.Time {
+	if len(value) != 8 {
+		return time.Time{}
+	}
+	return time.Unix(0, int64(binary.BigEndian.Uint64(value)))
+}
+
+func (s *BoltStore) EnqueueNotification(ctx context.Context, notification models.Notification) error {
+	value, err := json.Marshal(notification)
+	if err != nil {
+		return err
+	}
+
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(notificationsBucket).Put([]byte(notification.ID), value)
+	})
+}
+
+// ClaimNotifications decodes the whole queue on every claim, which is cheap
+// enough for the single instance and the few notifications the store is
+// meant for.
+func (s *BoltStore) ClaimNotifications(ctx context.Context, now time.Time, limit int, claimTimeout time.Duration) ([]models.Notification, error) {
+	due := []models.Notification{}
+
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(notificationsBucket)
+		invalid := map[string][]byte{}
+		err := bucket.ForEach(func(key, value []byte) error {
+			notification, err := decodeNotification(string(key), value)
+			if err != nil {
+				log.Println("Skipping notification:", err)
+				invalid[string(key)] = value
+				return nil
+			}
+			if !notification.NextAttemptAt.After(now) {
+				due = append(due, *notification)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		// the record is kept for inspection, but not claimed again
+		for key, value := range invalid {
+			if err := tx.Bucket(invalidBucket).Put([]byte(key), value); err != nil {
+				return err
+			}
+			if err := bucket.Delete([]byte(key)); err != nil {
+				return err
+			}
+		}
+
+		sort.Slice(due, func(i, j int) bool {
+			return due[i].NextAttemptAt.Before(due[j].NextAttemptAt)
+		})
+		if len(due) > limit {
+			due = due[:limit]
+		}
+
+		for _, notification := range due {
+			claimed := notification
+			claimed.NextAttemptAt = now.Add(claimTimeout)
+			value, err := json.Marshal(claimed)
+			if err != nil {
+				return err
+			}
+			if err := bucket.Put([]byte(claimed.ID), value); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return due, nil
+}
+
+func (s *BoltStore) DeleteNotification(ctx context.Context, id string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(notificationsBucket).Delete([]byte(id))
+	})
+}
+
+func (s *BoltStore) DeadLetterNotification(ctx context.Context, notification models.Notification) error {
+	value, err := json.Marshal(notification)
+	if err != nil {
+		return err
+	}
+
+	return s.db.Update(func(tx *bolt.Tx) error {
+		if err := tx.Bucket(notificationsBucket).Delete([]byte(notification.ID)); err != nil {
+			return err
+		}
+		return tx.Bucket(deadLettersBucket).Put([]byte(notification.ID), value)
+	})
+}
+
+func (s *BoltStore) ListDeadLetters(ctx context.Context) ([]models.Notification, error) {
+	deadLetters := []models.Notification{}
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(deadLettersBucket).ForEach(func(key, value []byte) error {
+			notification, err := decodeNotification(string(key), value)
+			if err != nil {
+				log.Println("Skipping dead letter:", err)
+				return nil
+			}
+			deadLetters = append(deadLetters, *notification)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(deadLetters, func(i, j int) bool {
+		return deadLetters[i].CreatedAt.Before(deadLetters[j].CreatedAt)
+	})
+	return deadLetters, nil
+}
+
+func (s *BoltStore) GetDeadLetter(ctx context.Context, id string) (*models.Notification, error) {
+	var notification *models.Notification
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		value := tx.Bucket(deadLettersBucket).Get([]byte(id))
+		if value == nil {
+			return ErrNotificationNotFound
+		}
+		var err error
+		notification, err = decodeNotification(id, value)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return notification, nil
+}
+
+func (s *BoltStore) DeleteDeadLetter(ctx context.Context, id string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(deadLettersBucket)
+		if bucket.Get([]byte(id)) == nil {
+			return ErrNotificationNotFound
+		}
+		return bucket.Delete([]byte(id))
+	})
+}
+
+func (s *BoltStore) Close() error {
+	return s.db.Close()
+}
@@ -1,26 +1,257 @@
 package database
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
 	"github.com/bxxf/regiojet-watchdog/internal/config"
-	"github.com/go-redis/redis/v8"
+	"github.com/bxxf/regiojet-watchdog/internal/models"
+	"go.uber.org/fx"
+)
+
+var (
+	ErrWatchdogNotFound     = errors.New("watchdog not found")
+	ErrNotificationNotFound = errors.New("notification not found")
 )
 
-type DatabaseClient struct {
-	RedisClient *redis.Client
+// invalidRecordError is returned for a stored record that cannot be decoded.
+// Listings and claims skip such records rather than failing because of one of
+// them.
+type invalidRecordError struct {
+	message string
+}
+
+func (e *invalidRecordError) Error() string {
+	return e.message
+}
+
+func invalidRecord(format string, args ...interface{}) error {
+	return &invalidRecordError{message: fmt.Sprintf(format, args...)}
+}
+
+func isInvalidRecord(err error) bool {
+	var recordErr *invalidRecordError
+	return errors.As(err, &recordErr)
+}
+
+// WatchdogStore persists watchdogs and when each of them is checked next.
+// Watchdogs whose ExpiresAt has passed are treated as deleted.
+type WatchdogStore interface {
+	// SaveWatchdog stores the watchdog. A new watchdog is due to be checked
+	// right away.
+	SaveWatchdog(ctx context.Context, watchdog models.Watchdog) error
+	GetWatchdog(ctx context.Context, id string) (*models.Watchdog, error)
+	ListWatchdogs(ctx context.Context) ([]models.Watchdog, error)
+	DeleteWatchdog(ctx context.Context, id string) error
+	// RecordCheck sets the LastSnapshot of the watchdog, and its
+	// LastNotifiedAt unless notifiedAt is nil, leaving the fields that may
+	// have been updated during the check as they are. It returns
+	// ErrWatchdogNotFound if the watchdog has been deleted.
+	RecordCheck(ctx context.Context, id string, snapshot models.AvailabilitySnapshot, notifiedAt *time.Time) error
+	// UpdateWatchdog applies update to the stored watchdog and stores the
+	// result, failing if update returns an error. Fields that update leaves
+	// alone keep the value they have when it runs, which may be after a
+	// check was recorded; update may be called more than once. It returns
+	// ErrWatchdogNotFound if the watchdog has been deleted.
+	UpdateWatchdog(ctx context.Context, id string, update func(watchdog *models.Watchdog) error) error
+	// ScheduleCheck sets when the watchdog is checked next. It does nothing
+	// if the watchdog has been deleted.
+	ScheduleCheck(ctx context.Context, id string, at time.Time) error
+	// ClaimDueWatchdogs returns the IDs of up to limit watchdogs that are due
+	// at now and moves their next check to now+claimTimeout, so a check that
+	// never finishes is retried.
+	ClaimDueWatchdogs(ctx context.Context, now time.Time, limit int, claimTimeout time.Duration) ([]string, error)
+
+	// AcquireLease makes owner the only instance checking the watchdog for
+	// ttl, returning false if another owner holds the lease.
+	AcquireLease(ctx context.Context, id, owner string, ttl time.Duration) (bool, error)
+	// RenewLease extends a lease held by owner, returning false if it has
+	// expired or is held by another owner.
+	RenewLease(ctx context.Context, id, owner string, ttl time.Duration) (bool, error)
+	// ReleaseLease gives up a lease held by owner.
+	ReleaseLease(ctx context.Context, id, owner string) error
+	Close() error
+}
+
+// NotificationStore queues notifications until they are delivered and keeps
+// the ones that could not be delivered in a dead-letter list.
+type NotificationStore interface {
+	// EnqueueNotification adds the notification to the queue, or reschedules
+	// it if it is queued already, to be sent at its NextAttemptAt.
+	EnqueueNotification(ctx context.Context, notification models.Notification) error
+	// ClaimNotifications returns up to limit notifications that are due at
+	// now and hides them from other claims for claimTimeout, so a sender that
+	// dies while sending does not lose them. Notifications that cannot be
+	// decoded are taken out of the queue.
+	ClaimNotifications(ctx context.Context, now time.Time, limit int, claimTimeout time.Duration) ([]models.Notification, error)
+	// DeleteNotification removes a delivered notification from the queue.
+	DeleteNotification(ctx context.Context, id string) error
+	// DeadLetterNotification moves the notification from the queue to the
+	// dead-letter list.
+	DeadLetterNotification(ctx context.Context, notification models.Notification) error
+	ListDeadLetters(ctx context.Context) ([]models.Notification, error)
+	GetDeadLetter(ctx context.Context, id string) (*models.Notification, error)
+	DeleteDeadLetter(ctx context.Context, id string) error
+}
+
+// Store is implemented by every storage backend.
+type Store interface {
+	WatchdogStore
+	NotificationStore
+}
+
+// lease is a lease on the checks of a watchdog, stored by the backends
+// without expiring keys.
+type lease struct {
+	Owner     string    `json:"owner"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+type migrator interface {
+	MigrateWatchdogs(ctx context.Context) error
+}
+
+func NewStore(config config.Config) (Store, error) {
+	switch config.StorageBackend {
+	case "redis":
+		return NewRedisStore(config.RedisURL)
+	case "bolt":
+		return NewBoltStore(config.StoragePath)
+	case "memory":
+		return NewMemoryStore(), nil
+	default:
+		return nil, fmt.Errorf("unknown storage backend %q", config.StorageBackend)
+	}
+}
+
+// decodeWatchdog decodes a JSON watchdog record of any supported version.
+func decodeWatchdog(id string, value []byte) (*models.Watchdog, error) {
+	var record struct {
+		models.Watchdog
+		// WebhookURL is the Discord webhook of version 1 records.
+		WebhookURL string `json:"webhookURL"`
+	}
+	if err := json.Unmarshal(value, &record); err != nil {
+		return nil, invalidRecord("failed to decode watchdog %s: %v", id, err)
+	}
+
+	watchdog := record.Watchdog
+	if watchdog.Version > models.WatchdogVersion {
+		return nil, invalidRecord("watchdog %s has unsupported version %d", id, watchdog.Version)
+	}
+	if watchdog.Version < 2 && record.WebhookURL != "" {
+		watchdog.Targets = []models.NotificationTarget{legacyTarget(record.WebhookURL)}
+	}
+	watchdog.Version = models.WatchdogVersion
+	return &watchdog, nil
+}
+
+// decodeNotification decodes a JSON notification record, converting
+// alternatives queued in the format used before segment paths.
+func decodeNotification(id string, value []byte) (*models.Notification, error) {
+	var record struct {
+		models.Notification
+		Alternatives json.RawMessage `json:"alternatives"`
+	}
+	if err := json.Unmarshal(value, &record); err != nil {
+		return nil, invalidRecord("failed to decode notification %s: %v", id, err)
+	}
+
+	notification := record.Notification
+	if len(record.Alternatives) > 0 {
+		if err := json.Unmarshal(record.Alternatives, &notification.Alternatives); err != nil {
+			var legacy [][]map[string]string
+			if json.Unmarshal(record.Alternatives, &legacy) != nil {
+				return nil, invalidRecord("failed to decode notification %s: %v", id, err)
+			}
+			notification.Alternatives = legacyAlternatives(legacy)
+		}
+	}
+	return &notification, nil
+}
+
+// legacyAlternatives converts alternatives of the old format, where every
+// path ended with an element holding only its totalPrice. The station IDs and
+// routes were not kept, so they stay empty.
+func legacyAlternatives(legacy [][]map[string]string) []models.SegmentPath {
+	paths := make([]models.SegmentPath, 0, len(legacy))
+	for _, segments := range legacy {
+		var path models.SegmentPath
+		for _, segment := range segments {
+			if totalPrice, ok := segment["totalPrice"]; ok {
+				path.TotalPrice, _ = strconv.ParseFloat(totalPrice, 64)
+				continue
+			}
+
+			departureTime, _ := time.ParseInLocation("02.01.2006 15:04", segment["departureDate"]+" "+segment["departureTime"], time.Local)
+			arrivalTime, _ := time.ParseInLocation("02.01.2006 15:04", segment["departureDate"]+" "+segment["arrivalTime"], time.Local)
+			if arrivalTime.Before(departureTime) {
+				arrivalTime = arrivalTime.AddDate(0, 0, 1)
+			}
+			freeSeats, _ := strconv.Atoi(segment["freeSeats"])
+			price, _ := strconv.ParseFloat(segment["price"], 64)
+			path.Segments = append(path.Segments, models.Segment{
+				FromStationName: segment["from"],
+				ToStationName:   segment["to"],
+				DepartureTime:   departureTime,
+				ArrivalTime:     arrivalTime,
+				Price:           price,
+				FreeSeats:       freeSeats,
+			})
+		}
+		paths = append(paths, path)
+	}
+	return paths
+}
+
+// legacyTarget returns the Discord target that replaces the webhook URL of
+// watchdogs stored before version 2. It is spelled out here rather than built
+// by the discord package, so old records keep migrating the same way.
+func legacyTarget(webhookURL string) models.NotificationTarget {
+	return models.NotificationTarget{
+		Type:     "discord",
+		Settings: map[string]string{"webhookURL": webhookURL},
+	}
 }
 
-func NewDatabaseClient(config config.Config) *DatabaseClient {
-	opt, err := redis.ParseURL(config.RedisURL)
-	if err != nil {
-		panic(err)
+func recordCheck(watchdog *models.Watchdog, snapshot models.AvailabilitySnapshot, notifiedAt *time.Time) {
+	watchdog.LastSnapshot = &snapshot
+	if notifiedAt != nil {
+		watchdog.LastNotifiedAt = notifiedAt
 	}
+}
 
-	client := redis.NewClient(opt)
-	_, err = client.Ping(client.Context()).Result()
-	if err != nil {
-		panic(err)
+// watchdogTTL returns how long the watchdog should be kept, zero meaning
+// forever.
+func watchdogTTL(watchdog models.Watchdog) (time.Duration, error) {
+	if watchdog.ExpiresAt.IsZero() {
+		return 0, nil
 	}
-	return &DatabaseClient{
-		RedisClient: client,
+	ttl := time.Until(watchdog.ExpiresAt)
+	if ttl <= 0 {
+		return 0, fmt.Errorf("watchdog %s has already expired", watchdog.ID)
 	}
+	return ttl, nil
+}
+
+func isExpired(watchdog models.Watchdog, now time.Time) bool {
+	return !watchdog.ExpiresAt.IsZero() && !now.Before(watchdog.ExpiresAt)
+}
+
+func RegisterDatabaseHooks(lc fx.Lifecycle, store WatchdogStore) {
+	lc.Append(fx.Hook{
+		OnStart: func(ctx context.Context) error {
+			if m, ok := store.(migrator); ok {
+				return m.MigrateWatchdogs(ctx)
+			}
+			return nil
+		},
+		OnStop: func(context.Context) error {
+			return store.Close()
+		},
+	})
 }
@@ -0,0 +1,290 @@
+package database
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/bxxf/regiojet-watchdog/internal/models"
+)
+
+// MemoryStore keeps watchdogs and queued notifications in process memory, so
+// they are lost on restart.
+type MemoryStore struct {
+	mu            sync.Mutex
+	watchdogs     map[string]models.Watchdog
+	nextChecks    map[string]time.Time
+	leases        map[string]lease
+	notifications map[string]models.Notification
+	deadLetters   map[string]models.Notification
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		watchdogs:     make(map[string]models.Watchdog),
+		nextChecks:    make(map[string]time.Time),
+		leases:        make(map[string]lease),
+		notifications: make(map[string]models.Notification),
+		deadLetters:   make(map[string]models.Notification),
+	}
+}
+
+func (s *MemoryStore) SaveWatchdog(ctx context.Context, watchdog models.Watchdog) error {
+	if _, err := watchdogTTL(watchdog); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	watchdog.Version = models.WatchdogVersion
+	s.watchdogs[watchdog.ID] = watchdog
+	if _, ok := s.nextChecks[watchdog.ID]; !ok {
+		s.nextChecks[watchdog.ID] = time.Now()
+	}
+	return nil
+}
+
+func (s *MemoryStore) GetWatchdog(ctx context.Context, id string) (*models.Watchdog, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	watchdog, ok := s.watchdogs[id]
+	if !ok {
+		return nil, ErrWatchdogNotFound
+	}
+	if isExpired(watchdog, time.Now()) {
+		s.remove(id)
+		return nil, ErrWatchdogNotFound
+	}
+	return &watchdog, nil
+}
+
+func (s *MemoryStore) ListWatchdogs(ctx context.Context) ([]models.Watchdog, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	watchdogs := []models.Watchdog{}
+	for id, watchdog := range s.watchdogs {
+		if isExpired(watchdog, now) {
+			s.remove(id)
+			continue
+		}
+		watchdogs = append(watchdogs, watchdog)
+	}
+
+	sort.Slice(watchdogs, func(i, j int) bool {
+		return watchdogs[i].CreatedAt.Before(watchdogs[j].CreatedAt)
+	})
+	return watchdogs, nil
+}
+
+func (s *MemoryStore) DeleteWatchdog(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	watchdog, ok := s.watchdogs[id]
+	s.remove(id)
+	if !ok || isExpired(watchdog, time.Now()) {
+		return ErrWatchdogNotFound
+	}
+	return nil
+}
+
+func (s *MemoryStore) RecordCheck(ctx context.Context, id string, snapshot models.AvailabilitySnapshot, notifiedAt *time.Time) error {
+	return s.UpdateWatchdog(ctx, id, func(watchdog *models.Watchdog) error {
+		recordCheck(watchdog, snapshot, notifiedAt)
+		return nil
+	})
+}
+
+func (s *MemoryStore) UpdateWatchdog(ctx context.Context, id string, update func(watchdog *models.Watchdog) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	watchdog, ok := s.watchdogs[id]
+	if !ok || isExpired(watchdog, time.Now()) {
+		return ErrWatchdogNotFound
+	}
+	if err := update(&watchdog); err != nil {
+		return err
+	}
+	if _, err := watchdogTTL(watchdog); err != nil {
+		return err
+	}
+
+	watchdog.Version = models.WatchdogVersion
+	s.watchdogs[id] = watchdog
+	return nil
+}
+
+func (s *MemoryStore) ScheduleCheck(ctx context.Context, id string, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.nextChecks[id]; ok {
+		s.nextChecks[id] = at
+	}
+	return nil
+}
+
+func (s *MemoryStore) ClaimDueWatchdogs(ctx context.Context, now time.Time, limit int, claimTimeout time.Duration) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var due []string
+	for id, at := range s.nextChecks {
+		watchdog, ok := s.watchdogs[id]
+		if !ok || isExpired(watchdog, now) {
+			s.remove(id)
+			continue
+		}
+		if !at.After(now) {
+			due = append(due, id)
+		}
+	}
+	sort.Slice(due, func(i, j int) bool {
+		return s.nextChecks[due[i]].Before(s.nextChecks[due[j]])
+	})
+	if len(due) > limit {
+		due = due[:limit]
+	}
+
+	for _, id := range due {
+		s.nextChecks[id] = now.Add(claimTimeout)
+	}
+	return due, nil
+}
+
+func (s *MemoryStore) AcquireLease(ctx context.Context, id, owner string, ttl time.Duration) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if current, ok := s.leases[id]; ok && current.Owner != owner && now.Before(current.ExpiresAt) {
+		return false, nil
+	}
+	s.leases[id] = lease{Owner: owner, ExpiresAt: now.Add(ttl)}
+	return true, nil
+}
+
+func (s *MemoryStore) RenewLease(ctx context.Context, id, owner string, ttl time.Duration) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if current, ok := s.leases[id]; !ok || current.Owner != owner || !now.Before(current.ExpiresAt) {
+		return false, nil
+	}
+	s.leases[id] = lease{Owner: owner, ExpiresAt: now.Add(ttl)}
+	return true, nil
+}
+
+func (s *MemoryStore) ReleaseLease(ctx context.Context, id, owner string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.leases[id].Owner == owner {
+		delete(s.leases, id)
+	}
+	return nil
+}
+
+// remove deletes the watchdog and its schedule, the caller holding the lock.
+func (s *MemoryStore) remove(id string) {
+	delete(s.watchdogs, id)
+	delete(s.nextChecks, id)
+	delete(s.leases, id)
+}
+
+func (s *MemoryStore) EnqueueNotification(ctx context.Context, notification models.Notification) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.notifications[notification.ID] = notification
+	return nil
+}
+
+func (s *MemoryStore) ClaimNotifications(ctx context.Context, now time.Time, limit int, claimTimeout time.Duration) ([]models.Notification, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	due := []models.Notification{}
+	for _, notification := range s.notifications {
+		if !notification.NextAttemptAt.After(now) {
+			due = append(due, notification)
+		}
+	}
+	sort.Slice(due, func(i, j int) bool {
+		return due[i].NextAttemptAt.Before(due[j].NextAttemptAt)
+	})
+	if len(due) > limit {
+		due = due[:limit]
+	}
+
+	for _, notification := range due {
+		claimed := notification
+		claimed.NextAttemptAt = now.Add(claimTimeout)
+		s.notifications[claimed.ID] = claimed
+	}
+	return due, nil
+}
+
+func (s *MemoryStore) DeleteNotification(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.notifications, id)
+	return nil
+}
+
+func (s *MemoryStore) DeadLetterNotification(ctx context.Context, notification models.Notification) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.notifications, notification.ID)
+	s.deadLetters[notification.ID] = notification
+	return nil
+}
+
+func (s *MemoryStore) ListDeadLetters(ctx context.Context) ([]models.Notification, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	deadLetters := []models.Notification{}
+	for _, notification := range s.deadLetters {
+		deadLetters = append(deadLetters, notification)
+	}
+	sort.Slice(deadLetters, func(i, j int) bool {
+		return deadLetters[i].CreatedAt.Before(deadLetters[j].CreatedAt)
+	})
+	return deadLetters, nil
+}
+
+func (s *MemoryStore) GetDeadLetter(ctx context.Context, id string) (*models.Notification, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	notification, ok := s.deadLetters[id]
+	if !ok {
+		return nil, ErrNotificationNotFound
+	}
+	return &notification, nil
+}
+
+func (s *MemoryStore) DeleteDeadLetter(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.deadLetters[id]; !ok {
+		return ErrNotificationNotFound
+	}
+	delete(s.deadLetters, id)
+	return nil
+}
+
+func (s *MemoryStore) Close() error {
+	return nil
+}
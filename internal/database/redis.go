@@ -0,0 +1,496 @@
+package database
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/bxxf/regiojet-watchdog/internal/models"
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	watchdogKeyPrefix = "watchdog:"
+	// watchdogsDueKey is a sorted set of all watchdog IDs scored by the unix
+	// milliseconds of their next check.
+	watchdogsDueKey = "watchdogs:due"
+	// leaseKeyPrefix is followed by the watchdog ID. The value is the owner
+	// of the lease, which expires with the key.
+	leaseKeyPrefix = "lease:watchdog:"
+
+	// Queued notifications are stored under notification:<id> and scheduled
+	// in a sorted set scored by the unix milliseconds of their next attempt.
+	notificationKeyPrefix = "notification:"
+	notificationQueueKey  = "notifications:queue"
+
+	// Dead letters are stored under deadletter:<id> and listed in a sorted set
+	// scored by the unix milliseconds they were dead-lettered at.
+	deadLetterKeyPrefix = "deadletter:"
+	deadLettersKey      = "notifications:deadletters"
+
+	// maxUpdateAttempts is how often updating a watchdog is tried while it
+	// is changed concurrently.
+	maxUpdateAttempts = 5
+)
+
+// claimWatchdogsScript moves up to ARGV[2] watchdogs due at ARGV[1] to
+// ARGV[3] in KEYS[1] and returns their IDs. Watchdogs whose record under the
+// prefix ARGV[4] has expired are removed instead.
+var claimWatchdogsScript = redis.NewScript(`
+local ids = redis.call('ZRANGEBYSCORE', KEYS[1], '-inf', ARGV[1], 'LIMIT', 0, ARGV[2])
+local claimed = {}
+for _, id in ipairs(ids) do
+	if redis.call('EXISTS', ARGV[4] .. id) == 1 then
+		redis.call('ZADD', KEYS[1], ARGV[3], id)
+		table.insert(claimed, id)
+	else
+		redis.call('ZREM', KEYS[1], id)
+	end
+end
+return claimed
+`)
+
+// renewLeaseScript extends the lease KEYS[1] by ARGV[2] milliseconds if it
+// is held by ARGV[1].
+var renewLeaseScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('PEXPIRE', KEYS[1], ARGV[2])
+end
+return 0
+`)
+
+// releaseLeaseScript deletes the lease KEYS[1] if it is held by ARGV[1].
+var releaseLeaseScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('DEL', KEYS[1])
+end
+return 0
+`)
+
+// claimNotificationsScript moves up to ARGV[2] notifications due at ARGV[1]
+// to ARGV[3] in the queue KEYS[1] and returns their IDs.
+var claimNotificationsScript = redis.NewScript(`
+local ids = redis.call('ZRANGEBYSCORE', KEYS[1], '-inf', ARGV[1], 'LIMIT', 0, ARGV[2])
+for _, id in ipairs(ids) do
+	redis.call('ZADD', KEYS[1], ARGV[3], id)
+end
+return ids
+`)
+
+type RedisStore struct {
+	client *redis.Client
+}
+
+func NewRedisStore(redisURL string) (*RedisStore, error) {
+	opt, err := redis.ParseURL(redisURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid REDIS_URL: %v", err)
+	}
+
+	client := redis.NewClient(opt)
+	if err := client.Ping(client.Context()).Err(); err != nil {
+		client.Close()
+		return nil, fmt.Errorf("failed to connect to redis: %v", err)
+	}
+	return &RedisStore{
+		client: client,
+	}, nil
+}
+
+// SaveWatchdog stores the watchdog as a JSON record that expires at
+// watchdog.ExpiresAt, or never if ExpiresAt is zero.
+func (s *RedisStore) SaveWatchdog(ctx context.Context, watchdog models.Watchdog) error {
+	ttl, err := watchdogTTL(watchdog)
+	if err != nil {
+		return err
+	}
+
+	watchdog.Version = models.WatchdogVersion
+	value, err := json.Marshal(watchdog)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Set(ctx, watchdogKeyPrefix+watchdog.ID, value, ttl)
+		pipe.ZAddNX(ctx, watchdogsDueKey, &redis.Z{
+			Score:  float64(time.Now().UnixMilli()),
+			Member: watchdog.ID,
+		})
+		return nil
+	})
+	return err
+}
+
+func (s *RedisStore) GetWatchdog(ctx context.Context, id string) (*models.Watchdog, error) {
+	return s.getWatchdog(ctx, s.client, id)
+}
+
+// getWatchdog reads the watchdog through c, the client or a transaction.
+func (s *RedisStore) getWatchdog(ctx context.Context, c redis.Cmdable, id string) (*models.Watchdog, error) {
+	key := watchdogKeyPrefix + id
+
+	value, err := c.Get(ctx, key).Result()
+	if err == redis.Nil {
+		return nil, ErrWatchdogNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if isLegacyValue(value) {
+		ttl, err := c.PTTL(ctx, key).Result()
+		if err != nil {
+			return nil, err
+		}
+		return parseLegacyValue(id, value, ttl)
+	}
+
+	return decodeWatchdog(id, []byte(value))
+}
+
+// ListWatchdogs lists the watchdogs in the order they are due, reading the
+// IDs from the due set rather than scanning the keyspace.
+func (s *RedisStore) ListWatchdogs(ctx context.Context) ([]models.Watchdog, error) {
+	ids, err := s.client.ZRange(ctx, watchdogsDueKey, 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	watchdogs := []models.Watchdog{}
+	for _, id := range ids {
+		watchdog, err := s.GetWatchdog(ctx, id)
+		if errors.Is(err, ErrWatchdogNotFound) {
+			// expired, the next claim removes it from the due set
+			continue
+		}
+		if isInvalidRecord(err) {
+			log.Println("Skipping watchdog:", err)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		watchdogs = append(watchdogs, *watchdog)
+	}
+	return watchdogs, nil
+}
+
+func (s *RedisStore) DeleteWatchdog(ctx context.Context, id string) error {
+	var deleted *redis.IntCmd
+	_, err := s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		deleted = pipe.Del(ctx, watchdogKeyPrefix+id)
+		pipe.ZRem(ctx, watchdogsDueKey, id)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if deleted.Val() == 0 {
+		return ErrWatchdogNotFound
+	}
+	return nil
+}
+
+// RecordCheck updates the record in a transaction that fails if the record
+// changes after it is read, and tries again with the changed record.
+func (s *RedisStore) RecordCheck(ctx context.Context, id string, snapshot models.AvailabilitySnapshot, notifiedAt *time.Time) error {
+	return s.UpdateWatchdog(ctx, id, func(watchdog *models.Watchdog) error {
+		recordCheck(watchdog, snapshot, notifiedAt)
+		return nil
+	})
+}
+
+// UpdateWatchdog updates the record in a transaction that fails if the record
+// changes after it is read, and tries again with the changed record.
+func (s *RedisStore) UpdateWatchdog(ctx context.Context, id string, update func(watchdog *models.Watchdog) error) error {
+	key := watchdogKeyPrefix + id
+	for attempt := 0; attempt < maxUpdateAttempts; attempt++ {
+		err := s.client.Watch(ctx, func(tx *redis.Tx) error {
+			watchdog, err := s.getWatchdog(ctx, tx, id)
+			if err != nil {
+				return err
+			}
+			if err := update(watchdog); err != nil {
+				return err
+			}
+
+			ttl, err := watchdogTTL(*watchdog)
+			if err != nil {
+				return ErrWatchdogNotFound
+			}
+			watchdog.Version = models.WatchdogVersion
+			value, err := json.Marshal(watchdog)
+			if err != nil {
+				return err
+			}
+
+			_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+				pipe.Set(ctx, key, value, ttl)
+				return nil
+			})
+			return err
+		}, key)
+		if err != redis.TxFailedErr {
+			return err
+		}
+	}
+	return fmt.Errorf("watchdog %s kept changing while it was updated", id)
+}
+
+func (s *RedisStore) ScheduleCheck(ctx context.Context, id string, at time.Time) error {
+	return s.client.ZAddXX(ctx, watchdogsDueKey, &redis.Z{
+		Score:  float64(at.UnixMilli()),
+		Member: id,
+	}).Err()
+}
+
+func (s *RedisStore) ClaimDueWatchdogs(ctx context.Context, now time.Time, limit int, claimTimeout time.Duration) ([]string, error) {
+	return claimWatchdogsScript.Run(ctx, s.client, []string{watchdogsDueKey},
+		now.UnixMilli(), limit, now.Add(claimTimeout).UnixMilli(), watchdogKeyPrefix).StringSlice()
+}
+
+func (s *RedisStore) AcquireLease(ctx context.Context, id, owner string, ttl time.Duration) (bool, error) {
+	key := leaseKeyPrefix + id
+	acquired, err := s.client.SetNX(ctx, key, owner, ttl).Result()
+	if err != nil || acquired {
+		return acquired, err
+	}
+	// the owner may acquire its own lease again
+	return s.RenewLease(ctx, id, owner, ttl)
+}
+
+func (s *RedisStore) RenewLease(ctx context.Context, id, owner string, ttl time.Duration) (bool, error) {
+	renewed, err := renewLeaseScript.Run(ctx, s.client, []string{leaseKeyPrefix + id}, owner, ttl.Milliseconds()).Int()
+	return renewed == 1, err
+}
+
+func (s *RedisStore) ReleaseLease(ctx context.Context, id, owner string) error {
+	return releaseLeaseScript.Run(ctx, s.client, []string{leaseKeyPrefix + id}, owner).Err()
+}
+
+func (s *RedisStore) EnqueueNotification(ctx context.Context, notification models.Notification) error {
+	value, err := json.Marshal(notification)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Set(ctx, notificationKeyPrefix+notification.ID, value, 0)
+		pipe.ZAdd(ctx, notificationQueueKey, &redis.Z{
+			Score:  float64(notification.NextAttemptAt.UnixMilli()),
+			Member: notification.ID,
+		})
+		return nil
+	})
+	return err
+}
+
+func (s *RedisStore) ClaimNotifications(ctx context.Context, now time.Time, limit int, claimTimeout time.Duration) ([]models.Notification, error) {
+	ids, err := claimNotificationsScript.Run(ctx, s.client, []string{notificationQueueKey},
+		now.UnixMilli(), limit, now.Add(claimTimeout).UnixMilli()).StringSlice()
+	if err != nil {
+		return nil, err
+	}
+
+	notifications := []models.Notification{}
+	for _, id := range ids {
+		value, err := s.client.Get(ctx, notificationKeyPrefix+id).Bytes()
+		if err == redis.Nil {
+			s.client.ZRem(ctx, notificationQueueKey, id)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		notification, err := decodeNotification(id, value)
+		if err != nil {
+			// the record is kept for inspection, but not claimed again
+			log.Println("Skipping notification:", err)
+			s.client.ZRem(ctx, notificationQueueKey, id)
+			continue
+		}
+		notifications = append(notifications, *notification)
+	}
+	return notifications, nil
+}
+
+func (s *RedisStore) DeleteNotification(ctx context.Context, id string) error {
+	_, err := s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(ctx, notificationKeyPrefix+id)
+		pipe.ZRem(ctx, notificationQueueKey, id)
+		return nil
+	})
+	return err
+}
+
+func (s *RedisStore) DeadLetterNotification(ctx context.Context, notification models.Notification) error {
+	value, err := json.Marshal(notification)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(ctx, notificationKeyPrefix+notification.ID)
+		pipe.ZRem(ctx, notificationQueueKey, notification.ID)
+		pipe.Set(ctx, deadLetterKeyPrefix+notification.ID, value, 0)
+		pipe.ZAdd(ctx, deadLettersKey, &redis.Z{
+			Score:  float64(time.Now().UnixMilli()),
+			Member: notification.ID,
+		})
+		return nil
+	})
+	return err
+}
+
+func (s *RedisStore) ListDeadLetters(ctx context.Context) ([]models.Notification, error) {
+	ids, err := s.client.ZRange(ctx, deadLettersKey, 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	deadLetters := []models.Notification{}
+	for _, id := range ids {
+		notification, err := s.GetDeadLetter(ctx, id)
+		if errors.Is(err, ErrNotificationNotFound) {
+			continue
+		}
+		if isInvalidRecord(err) {
+			log.Println("Skipping dead letter:", err)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		deadLetters = append(deadLetters, *notification)
+	}
+	return deadLetters, nil
+}
+
+func (s *RedisStore) GetDeadLetter(ctx context.Context, id string) (*models.Notification, error) {
+	value, err := s.client.Get(ctx, deadLetterKeyPrefix+id).Bytes()
+	if err == redis.Nil {
+		return nil, ErrNotificationNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return decodeNotification(id, value)
+}
+
+func (s *RedisStore) DeleteDeadLetter(ctx context.Context, id string) error {
+	var deleted *redis.IntCmd
+	_, err := s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		deleted = pipe.Del(ctx, deadLetterKeyPrefix+id)
+		pipe.ZRem(ctx, deadLettersKey, id)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if deleted.Val() == 0 {
+		return ErrNotificationNotFound
+	}
+	return nil
+}
+
+// MigrateWatchdogs rewrites watchdogs stored in the legacy
+// "webhook;;from;;to;;routeID" format or in an older record version as
+// current JSON records, keeping their expiration. Watchdogs saved before the
+// due set existed are added to it.
+func (s *RedisStore) MigrateWatchdogs(ctx context.Context) error {
+	migrated := 0
+
+	err := s.scanWatchdogIDs(ctx, func(id string) error {
+		key := watchdogKeyPrefix + id
+		value, err := s.client.Get(ctx, key).Result()
+		if err == redis.Nil {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if !isLegacyValue(value) && recordVersion(value) == models.WatchdogVersion {
+			return s.client.ZAddNX(ctx, watchdogsDueKey, &redis.Z{
+				Score:  float64(time.Now().UnixMilli()),
+				Member: id,
+			}).Err()
+		}
+
+		watchdog, err := s.GetWatchdog(ctx, id)
+		if errors.Is(err, ErrWatchdogNotFound) {
+			return nil
+		}
+		if err != nil {
+			log.Println("Skipping watchdog migration:", err)
+			return nil
+		}
+
+		if err := s.SaveWatchdog(ctx, *watchdog); err != nil {
+			return err
+		}
+		migrated++
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if migrated > 0 {
+		log.Printf("Migrated %d watchdogs to version %d\n", migrated, models.WatchdogVersion)
+	}
+	return nil
+}
+
+func (s *RedisStore) scanWatchdogIDs(ctx context.Context, fn func(id string) error) error {
+	iter := s.client.Scan(ctx, 0, watchdogKeyPrefix+"*", 100).Iterator()
+	for iter.Next(ctx) {
+		if err := fn(strings.TrimPrefix(iter.Val(), watchdogKeyPrefix)); err != nil {
+			return err
+		}
+	}
+	return iter.Err()
+}
+
+func isLegacyValue(value string) bool {
+	return !strings.HasPrefix(value, "{")
+}
+
+func recordVersion(value string) int {
+	var record struct {
+		Version int `json:"version"`
+	}
+	json.Unmarshal([]byte(value), &record)
+	return record.Version
+}
+
+func parseLegacyValue(id, value string, ttl time.Duration) (*models.Watchdog, error) {
+	parts := strings.Split(value, ";;")
+	if len(parts) != 4 {
+		return nil, invalidRecord("invalid legacy value format of watchdog %s", id)
+	}
+
+	watchdog := &models.Watchdog{
+		Version:       models.WatchdogVersion,
+		ID:            id,
+		StationFromID: parts[1],
+		StationToID:   parts[2],
+		RouteID:       parts[3],
+		Targets:       []models.NotificationTarget{legacyTarget(parts[0])},
+		CreatedAt:     time.Now(),
+	}
+	if ttl > 0 {
+		watchdog.ExpiresAt = time.Now().Add(ttl)
+	}
+	return watchdog, nil
+}
+
+func (s *RedisStore) Close() error {
+	return s.client.Close()
+}
@@ -0,0 +1,477 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/bxxf/regiojet-watchdog/internal/models"
+	bolt "go.etcd.io/bbolt"
+)
+
+// stores returns a fresh store of every backend that runs without a server.
+func stores(t *testing.T) map[string]Store {
+	boltStore, err := NewBoltStore(filepath.Join(t.TempDir(), "watchdogs.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { boltStore.Close() })
+
+	return map[string]Store{
+		"memory": NewMemoryStore(),
+		"bolt":   boltStore,
+	}
+}
+
+func newWatchdog(id string) models.Watchdog {
+	return models.Watchdog{
+		ID:            id,
+		StationFromID: "372825000",
+		StationToID:   "508808000",
+		RouteID:       "101020261017",
+		Targets:       []models.NotificationTarget{{Type: "discord", Settings: map[string]string{"webhookURL": "https://example.com"}}},
+		CreatedAt:     time.Now(),
+		ExpiresAt:     time.Now().Add(time.Hour),
+	}
+}
+
+func TestWatchdogs(t *testing.T) {
+	for name, store := range stores(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			if err := store.SaveWatchdog(ctx, newWatchdog("a")); err != nil {
+				t.Fatal(err)
+			}
+			if err := store.SaveWatchdog(ctx, newWatchdog("b")); err != nil {
+				t.Fatal(err)
+			}
+
+			watchdog, err := store.GetWatchdog(ctx, "a")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if watchdog.RouteID != "101020261017" || watchdog.Version != models.WatchdogVersion {
+				t.Errorf("got watchdog %+v", watchdog)
+			}
+
+			watchdogs, err := store.ListWatchdogs(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(watchdogs) != 2 {
+				t.Errorf("listed %d watchdogs, want 2", len(watchdogs))
+			}
+
+			if err := store.DeleteWatchdog(ctx, "a"); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := store.GetWatchdog(ctx, "a"); !errors.Is(err, ErrWatchdogNotFound) {
+				t.Errorf("got %v for a deleted watchdog, want %v", err, ErrWatchdogNotFound)
+			}
+			if err := store.DeleteWatchdog(ctx, "a"); !errors.Is(err, ErrWatchdogNotFound) {
+				t.Errorf("got %v deleting a deleted watchdog, want %v", err, ErrWatchdogNotFound)
+			}
+		})
+	}
+}
+
+func TestExpiredWatchdogsAreDeleted(t *testing.T) {
+	for name, store := range stores(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			watchdog := newWatchdog("a")
+			watchdog.ExpiresAt = time.Now().Add(50 * time.Millisecond)
+			if err := store.SaveWatchdog(ctx, watchdog); err != nil {
+				t.Fatal(err)
+			}
+			time.Sleep(100 * time.Millisecond)
+
+			if _, err := store.GetWatchdog(ctx, "a"); !errors.Is(err, ErrWatchdogNotFound) {
+				t.Errorf("got %v for an expired watchdog, want %v", err, ErrWatchdogNotFound)
+			}
+			if watchdogs, err := store.ListWatchdogs(ctx); err != nil || len(watchdogs) != 0 {
+				t.Errorf("listed %v, %v, want no watchdogs", watchdogs, err)
+			}
+			if ids, err := store.ClaimDueWatchdogs(ctx, time.Now(), 10, time.Minute); err != nil || len(ids) != 0 {
+				t.Errorf("claimed %v, %v, want no watchdogs", ids, err)
+			}
+		})
+	}
+}
+
+func TestClaimDueWatchdogs(t *testing.T) {
+	for name, store := range stores(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			for _, id := range []string{"a", "b", "c"} {
+				if err := store.SaveWatchdog(ctx, newWatchdog(id)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			now := time.Now().Add(time.Second)
+			if err := store.ScheduleCheck(ctx, "c", now.Add(time.Hour)); err != nil {
+				t.Fatal(err)
+			}
+
+			ids, err := store.ClaimDueWatchdogs(ctx, now, 1, time.Minute)
+			if err != nil || len(ids) != 1 {
+				t.Fatalf("claimed %v, %v, want one watchdog", ids, err)
+			}
+			more, err := store.ClaimDueWatchdogs(ctx, now, 10, time.Minute)
+			if err != nil || len(more) != 1 || more[0] == ids[0] || more[0] == "c" {
+				t.Fatalf("claimed %v after %v, %v, want the other due watchdog", more, ids, err)
+			}
+			if ids, err := store.ClaimDueWatchdogs(ctx, now.Add(2*time.Minute), 10, time.Minute); err != nil || len(ids) != 2 {
+				t.Errorf("claimed %v, %v once the claims expired, want both again", ids, err)
+			}
+		})
+	}
+}
+
+func TestLeases(t *testing.T) {
+	for name, store := range stores(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			if acquired, err := store.AcquireLease(ctx, "a", "one", time.Minute); err != nil || !acquired {
+				t.Fatalf("acquired %v, %v, want the lease", acquired, err)
+			}
+			if acquired, err := store.AcquireLease(ctx, "a", "two", time.Minute); err != nil || acquired {
+				t.Errorf("acquired %v, %v, want the lease held by another owner", acquired, err)
+			}
+			if renewed, err := store.RenewLease(ctx, "a", "two", time.Minute); err != nil || renewed {
+				t.Errorf("renewed %v, %v, want the lease held by another owner", renewed, err)
+			}
+			if err := store.ReleaseLease(ctx, "a", "one"); err != nil {
+				t.Fatal(err)
+			}
+			if acquired, err := store.AcquireLease(ctx, "a", "two", time.Minute); err != nil || !acquired {
+				t.Errorf("acquired %v, %v, want the released lease", acquired, err)
+			}
+		})
+	}
+}
+
+func TestNotifications(t *testing.T) {
+	for name, store := range stores(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			now := time.Now()
+			notification := models.Notification{
+				ID:            "n",
+				Kind:          models.NotificationAlternatives,
+				Watchdog:      newWatchdog("a"),
+				CreatedAt:     now,
+				NextAttemptAt: now,
+			}
+			if err := store.EnqueueNotification(ctx, notification); err != nil {
+				t.Fatal(err)
+			}
+
+			claimed, err := store.ClaimNotifications(ctx, now, 10, time.Minute)
+			if err != nil || len(claimed) != 1 {
+				t.Fatalf("claimed %v, %v, want the notification", claimed, err)
+			}
+			if claimed, err := store.ClaimNotifications(ctx, now, 10, time.Minute); err != nil || len(claimed) != 0 {
+				t.Errorf("claimed %v, %v, want the notification hidden while claimed", claimed, err)
+			}
+
+			if err := store.DeadLetterNotification(ctx, claimed[0]); err != nil {
+				t.Fatal(err)
+			}
+			if claimed, err := store.ClaimNotifications(ctx, now.Add(time.Hour), 10, time.Minute); err != nil || len(claimed) != 0 {
+				t.Errorf("claimed %v, %v, want the dead letter out of the queue", claimed, err)
+			}
+			deadLetters, err := store.ListDeadLetters(ctx)
+			if err != nil || len(deadLetters) != 1 || deadLetters[0].ID != "n" {
+				t.Fatalf("listed %v, %v, want the dead letter", deadLetters, err)
+			}
+
+			if err := store.DeleteDeadLetter(ctx, "n"); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := store.GetDeadLetter(ctx, "n"); !errors.Is(err, ErrNotificationNotFound) {
+				t.Errorf("got %v for a deleted dead letter, want %v", err, ErrNotificationNotFound)
+			}
+		})
+	}
+}
+
+func TestListSkipsInvalidRecords(t *testing.T) {
+	store, err := NewBoltStore(filepath.Join(t.TempDir(), "watchdogs.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	ctx := context.Background()
+	if err := store.SaveWatchdog(ctx, newWatchdog("a")); err != nil {
+		t.Fatal(err)
+	}
+	err = store.db.Update(func(tx *bolt.Tx) error {
+		if err := tx.Bucket(watchdogsBucket).Put([]byte("broken"), []byte("{")); err != nil {
+			return err
+		}
+		return tx.Bucket(deadLettersBucket).Put([]byte("broken"), []byte("{"))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	watchdogs, err := store.ListWatchdogs(ctx)
+	if err != nil || len(watchdogs) != 1 || watchdogs[0].ID != "a" {
+		t.Errorf("listed %v, %v, want only the valid watchdog", watchdogs, err)
+	}
+	deadLetters, err := store.ListDeadLetters(ctx)
+	if err != nil || len(deadLetters) != 0 {
+		t.Errorf("listed %v, %v, want no dead letters", deadLetters, err)
+	}
+}
+
+func TestClaimSkipsInvalidNotifications(t *testing.T) {
+	store, err := NewBoltStore(filepath.Join(t.TempDir(), "watchdogs.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	ctx := context.Background()
+	now := time.Now()
+	if err := store.EnqueueNotification(ctx, models.Notification{ID: "a", NextAttemptAt: now}); err != nil {
+		t.Fatal(err)
+	}
+	err = store.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(notificationsBucket).Put([]byte("broken"), []byte("{"))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	claimed, err := store.ClaimNotifications(ctx, now, 10, time.Minute)
+	if err != nil || len(claimed) != 1 || claimed[0].ID != "a" {
+		t.Errorf("claimed %v, %v, want only the valid notification", claimed, err)
+	}
+
+	err = store.db.View(func(tx *bolt.Tx) error {
+		if tx.Bucket(notificationsBucket).Get([]byte("broken")) != nil {
+			t.Error("the invalid notification is still queued")
+		}
+		if value := tx.Bucket(invalidBucket).Get([]byte("broken")); string(value) != "{" {
+			t.Errorf("kept %q of the invalid notification, want the record", value)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRecordCheck(t *testing.T) {
+	for name, store := range stores(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			if err := store.SaveWatchdog(ctx, newWatchdog("a")); err != nil {
+				t.Fatal(err)
+			}
+
+			// updated while it was checked
+			updated := newWatchdog("a")
+			updated.CheckIntervalSeconds = 60
+			if err := store.SaveWatchdog(ctx, updated); err != nil {
+				t.Fatal(err)
+			}
+			notifiedAt := time.Now()
+			snapshot := models.AvailabilitySnapshot{FreeSeatsCount: 3, ObservedAt: notifiedAt}
+			if err := store.RecordCheck(ctx, "a", snapshot, &notifiedAt); err != nil {
+				t.Fatal(err)
+			}
+
+			watchdog, err := store.GetWatchdog(ctx, "a")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if watchdog.CheckIntervalSeconds != 60 {
+				t.Errorf("got interval %d, want the update kept", watchdog.CheckIntervalSeconds)
+			}
+			if watchdog.LastSnapshot == nil || watchdog.LastSnapshot.FreeSeatsCount != 3 || watchdog.LastNotifiedAt == nil {
+				t.Errorf("got snapshot %+v notified at %v, want the check recorded", watchdog.LastSnapshot, watchdog.LastNotifiedAt)
+			}
+
+			if err := store.RecordCheck(ctx, "a", snapshot, nil); err != nil {
+				t.Fatal(err)
+			}
+			if watchdog, err := store.GetWatchdog(ctx, "a"); err != nil || watchdog.LastNotifiedAt == nil {
+				t.Errorf("got %+v, %v, want the last notification kept", watchdog, err)
+			}
+		})
+	}
+}
+
+func TestUpdateWatchdog(t *testing.T) {
+	for name, store := range stores(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			if err := store.SaveWatchdog(ctx, newWatchdog("a")); err != nil {
+				t.Fatal(err)
+			}
+
+			// checked while it was updated
+			snapshot := models.AvailabilitySnapshot{FreeSeatsCount: 3, ObservedAt: time.Now()}
+			if err := store.RecordCheck(ctx, "a", snapshot, nil); err != nil {
+				t.Fatal(err)
+			}
+			err := store.UpdateWatchdog(ctx, "a", func(watchdog *models.Watchdog) error {
+				watchdog.CheckIntervalSeconds = 60
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			watchdog, err := store.GetWatchdog(ctx, "a")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if watchdog.CheckIntervalSeconds != 60 {
+				t.Errorf("got interval %d, want the update stored", watchdog.CheckIntervalSeconds)
+			}
+			if watchdog.LastSnapshot == nil || watchdog.LastSnapshot.FreeSeatsCount != 3 {
+				t.Errorf("got snapshot %+v, want the check kept", watchdog.LastSnapshot)
+			}
+
+			failed := errors.New("failed")
+			err = store.UpdateWatchdog(ctx, "a", func(watchdog *models.Watchdog) error {
+				watchdog.CheckIntervalSeconds = 120
+				return failed
+			})
+			if !errors.Is(err, failed) {
+				t.Errorf("got %v, want %v", err, failed)
+			}
+			if watchdog, err := store.GetWatchdog(ctx, "a"); err != nil || watchdog.CheckIntervalSeconds != 60 {
+				t.Errorf("got %+v, %v, want the failed update discarded", watchdog, err)
+			}
+
+			if err := store.DeleteWatchdog(ctx, "a"); err != nil {
+				t.Fatal(err)
+			}
+			err = store.UpdateWatchdog(ctx, "a", func(watchdog *models.Watchdog) error { return nil })
+			if !errors.Is(err, ErrWatchdogNotFound) {
+				t.Errorf("got %v updating a deleted watchdog, want %v", err, ErrWatchdogNotFound)
+			}
+		})
+	}
+}
+
+func TestRecordCheckOfDeletedWatchdog(t *testing.T) {
+	for name, store := range stores(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			if err := store.SaveWatchdog(ctx, newWatchdog("a")); err != nil {
+				t.Fatal(err)
+			}
+			if err := store.DeleteWatchdog(ctx, "a"); err != nil {
+				t.Fatal(err)
+			}
+
+			snapshot := models.AvailabilitySnapshot{ObservedAt: time.Now()}
+			if err := store.RecordCheck(ctx, "a", snapshot, nil); !errors.Is(err, ErrWatchdogNotFound) {
+				t.Errorf("got %v recording the check, want %v", err, ErrWatchdogNotFound)
+			}
+			if _, err := store.GetWatchdog(ctx, "a"); !errors.Is(err, ErrWatchdogNotFound) {
+				t.Errorf("got %v, want the watchdog to stay deleted", err)
+			}
+			if ids, err := store.ClaimDueWatchdogs(ctx, time.Now().Add(time.Hour), 10, time.Minute); err != nil || len(ids) != 0 {
+				t.Errorf("claimed %v, %v, want no watchdogs", ids, err)
+			}
+		})
+	}
+}
+
+func TestParseLegacyValue(t *testing.T) {
+	watchdog, err := parseLegacyValue("a", "https://discord.com/api/webhooks/1/token;;372825000;;508808000;;101020261017", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []models.NotificationTarget{{Type: "discord", Settings: map[string]string{"webhookURL": "https://discord.com/api/webhooks/1/token"}}}
+	if watchdog.Version != models.WatchdogVersion || watchdog.ID != "a" || !reflect.DeepEqual(watchdog.Targets, want) {
+		t.Errorf("got watchdog %+v", watchdog)
+	}
+	if watchdog.StationFromID != "372825000" || watchdog.StationToID != "508808000" || watchdog.RouteID != "101020261017" {
+		t.Errorf("got stations %s, %s and route %s", watchdog.StationFromID, watchdog.StationToID, watchdog.RouteID)
+	}
+	if until := time.Until(watchdog.ExpiresAt); until <= 0 || until > time.Hour {
+		t.Errorf("got expiration %v, want within the hour of the key", watchdog.ExpiresAt)
+	}
+
+	if _, err := parseLegacyValue("b", "https://example.com;;372825000", time.Hour); !isInvalidRecord(err) {
+		t.Errorf("got %v for a value of two parts, want an invalid record", err)
+	}
+}
+
+func TestDecodeWatchdog(t *testing.T) {
+	discordTarget := models.NotificationTarget{Type: "discord", Settings: map[string]string{"webhookURL": "https://discord.com/api/webhooks/1/token"}}
+	telegramTarget := models.NotificationTarget{Type: "telegram", Settings: map[string]string{"chatID": "42"}}
+	cases := []struct {
+		name  string
+		value string
+		want  []models.NotificationTarget
+	}{
+		{
+			name:  "version 1",
+			value: `{"version":1,"id":"a","routeID":"101020261017","webhookURL":"https://discord.com/api/webhooks/1/token"}`,
+			want:  []models.NotificationTarget{discordTarget},
+		},
+		{
+			name:  "no version",
+			value: `{"id":"a","routeID":"101020261017","webhookURL":"https://discord.com/api/webhooks/1/token"}`,
+			want:  []models.NotificationTarget{discordTarget},
+		},
+		{
+			name:  "current",
+			value: `{"version":2,"id":"a","routeID":"101020261017","targets":[{"type":"telegram","settings":{"chatID":"42"}}],"webhookURL":"https://example.com"}`,
+			want:  []models.NotificationTarget{telegramTarget},
+		},
+	}
+	for _, c := range cases {
+		watchdog, err := decodeWatchdog("a", []byte(c.value))
+		if err != nil {
+			t.Errorf("%s: %v", c.name, err)
+			continue
+		}
+		if watchdog.Version != models.WatchdogVersion || watchdog.RouteID != "101020261017" || !reflect.DeepEqual(watchdog.Targets, c.want) {
+			t.Errorf("%s: got watchdog %+v, want targets %v", c.name, watchdog, c.want)
+		}
+	}
+
+	if _, err := decodeWatchdog("a", []byte(`{"version":99}`)); !isInvalidRecord(err) {
+		t.Errorf("got %v for a newer version, want an invalid record", err)
+	}
+}
+
+func TestGetVersion1Watchdog(t *testing.T) {
+	store, err := NewBoltStore(filepath.Join(t.TempDir(), "watchdogs.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	expiresAt := time.Now().Add(time.Hour).UTC().Format(time.RFC3339Nano)
+	value := `{"version":1,"id":"a","stationFromID":"372825000","stationToID":"508808000","routeID":"101020261017","webhookURL":"https://discord.com/api/webhooks/1/token","expiresAt":"` + expiresAt + `"}`
+	err = store.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(watchdogsBucket).Put([]byte("a"), []byte(value))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	watchdog, err := store.GetWatchdog(context.Background(), "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []models.NotificationTarget{{Type: "discord", Settings: map[string]string{"webhookURL": "https://discord.com/api/webhooks/1/token"}}}
+	if watchdog.Version != models.WatchdogVersion || watchdog.StationToID != "508808000" || !reflect.DeepEqual(watchdog.Targets, want) {
+		t.Errorf("got watchdog %+v, want it upgraded with targets %v", watchdog, want)
+	}
+}
@@ -2,28 +2,92 @@ package discord
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/bxxf/regiojet-watchdog/internal/models"
+	"github.com/bxxf/regiojet-watchdog/internal/notifier"
 	"go.uber.org/zap"
 )
 
+const (
+	targetType = "discord"
+
+	// Limits of the Discord API for an embed, counted in characters, which
+	// counting bytes stays within.
+	maxFieldValue  = 1024
+	maxDescription = 4096
+	maxEmbedLength = 6000
+)
+
 type DiscordService struct {
 	logger *zap.Logger
+	client *http.Client
 }
 
 func NewDiscordService(logger *zap.Logger) *DiscordService {
 	return &DiscordService{
 		logger: logger,
+		client: &http.Client{},
+	}
+}
+
+// Target returns a notification target that posts to the Discord webhook.
+func Target(webhookURL string) models.NotificationTarget {
+	return models.NotificationTarget{
+		Type:     targetType,
+		Settings: map[string]string{"webhookURL": webhookURL},
+	}
+}
+
+func (s *DiscordService) Type() string {
+	return targetType
+}
+
+// SecretSettings hides the webhook URL, which anyone can post to.
+func (s *DiscordService) SecretSettings() []string {
+	return []string{"webhookURL"}
+}
+
+func (s *DiscordService) Validate(target models.NotificationTarget) error {
+	webhookURL := target.Settings["webhookURL"]
+	if !strings.HasPrefix(webhookURL, "https://") && !strings.HasPrefix(webhookURL, "http://") {
+		return errors.New("discord target requires a webhookURL setting")
 	}
+	return nil
 }
 
-func (s *DiscordService) NotifyDiscord(freeSeatsDetails models.FreeSeatsResponse, routeDetails models.RouteDetails, routeDeparture, webhookURL string) {
+func (s *DiscordService) NotifyFreeSeats(ctx context.Context, watchdog models.Watchdog, target models.NotificationTarget, freeSeats models.FreeSeatsResponse, routeDetails models.RouteDetails) error {
+	return s.NotifyDiscord(ctx, freeSeats, routeDetails, routeDetails.DepartureTime, target.Settings["webhookURL"])
+}
+
+func (s *DiscordService) NotifySoldOut(ctx context.Context, watchdog models.Watchdog, target models.NotificationTarget, routeDetails models.RouteDetails) error {
+	payload := map[string]interface{}{
+		"content": "",
+		"embeds": []map[string]interface{}{
+			{
+				"title":       fmt.Sprintf("Sold out (%s -> %s) - %s -> %s [%s]", routeDetails.DepartureCityName, routeDetails.ArrivalCityName, notifier.Clock(routeDetails.DepartureTime), notifier.Clock(routeDetails.ArrivalTime), notifier.Date(routeDetails.DepartureTime)),
+				"description": "There are no free seats left, you will be notified when some appear again.",
+				"color":       15158332,
+			},
+		},
+	}
+
+	return s.post(ctx, target.Settings["webhookURL"], payload)
+}
+
+func (s *DiscordService) NotifyAlternatives(ctx context.Context, watchdog models.Watchdog, target models.NotificationTarget, paths []models.SegmentPath) error {
+	return s.NotifyDiscordAlternatives(ctx, paths, target.Settings["webhookURL"])
+}
+
+func (s *DiscordService) NotifyDiscord(ctx context.Context, freeSeatsDetails models.FreeSeatsResponse, routeDetails models.RouteDetails, routeDeparture, webhookURL string) error {
 	if routeDetails.FreeSeatsCount == 0 {
-		return
+		return nil
 	}
 
 	departureTime, _ := time.Parse(time.RFC3339, routeDeparture)
@@ -69,91 +133,117 @@ func (s *DiscordService) NotifyDiscord(freeSeatsDetails models.FreeSeatsResponse
 		},
 	}
 
-	jsonPayload, err := json.Marshal(payload)
-	if err != nil {
-		s.logger.Fatal("Failed to marshal JSON payload", zap.Error(err))
-		return
-	}
-
-	resp, err := http.Post(webhookURL, "application/json", bytes.NewReader(jsonPayload))
-	if err != nil {
-		s.logger.Fatal("Failed to send Discord notification", zap.Error(err))
-	} else {
-		defer resp.Body.Close()
-		if resp.StatusCode != http.StatusNoContent {
-			s.logger.Error("Failed to send Discord notification", zap.Int("status", resp.StatusCode))
-		}
-	}
+	return s.post(ctx, webhookURL, payload)
 }
 
-func (s *DiscordService) NotifyDiscordAlternatives(allRoutes [][]map[string]string, webhookURL string) {
-	var alternatives []map[string]interface{}
+// NotifyDiscordAlternatives posts the alternatives as one embed, a field for
+// each. Alternatives that do not fit into the embed are left out, and so are
+// the last segments of an alternative too long for its field.
+func (s *DiscordService) NotifyDiscordAlternatives(ctx context.Context, paths []models.SegmentPath, webhookURL string) error {
+	from, to, date := notifier.Journey(paths)
+	title := fmt.Sprintf("Alternative routes %s -> %s (%s)", from, to, date)
+	footer := fmt.Sprintf("Last updated at %s", time.Now().Format("15:04:05"))
 
-	var routeInfo map[string]string
-	for _, route := range allRoutes {
-		var segmentsDescription string
-		totalPrice := route[len(route)-1]["totalPrice"]
+	// room is left for the note on the alternatives left out
+	room := maxEmbedLength - len(title) - len(footer) - len(moreAlternatives(len(paths)))
+	var alternatives []map[string]interface{}
+	for _, path := range paths {
+		name := fmt.Sprintf("Alternative route with Total Price: %.2f CZK, Seat Changes: %d, Train Changes: %d", path.TotalPrice, path.SeatChanges, path.Transfers)
+		value := segmentsDescription(path.Segments, maxFieldValue)
+		if len(name)+len(value) > room {
+			break
+		}
 
-		routeInfo = route[0]
+		alternatives = append(alternatives, map[string]interface{}{
+			"name":   name,
+			"value":  value,
+			"inline": false,
+		})
+		room -= len(name) + len(value)
+	}
 
-		if len(route) > 2 {
-			routeInfo["to"] = route[len(route)-2]["to"]
-		}
+	embed := map[string]interface{}{
+		"title":  title,
+		"color":  3447003,
+		"fields": alternatives,
+		"footer": map[string]interface{}{
+			"text": footer,
+		},
+	}
+	if len(alternatives) < len(paths) {
+		embed["description"] = moreAlternatives(len(paths) - len(alternatives))
+	}
+	payload := map[string]interface{}{
+		"content": "",
+		"embeds":  []map[string]interface{}{embed},
+	}
 
-		for i, segment := range route {
-			if i == len(route)-1 {
-				break
-			}
+	return s.post(ctx, webhookURL, payload)
+}
 
-			var realInfoTo string
-			if i == 0 && len(route) > 2 {
-				realInfoTo = route[i+1]["from"]
-			} else if len(route) < 3 {
-				realInfoTo = routeInfo["to"]
-			} else {
-				realInfoTo = segment["to"]
-			}
-			segmentsDescription += fmt.Sprintf("**%s -> %s** (Departure: %s, Arrival: %s) \n *Free Seats: %s, Price: %s CZK*\n",
-				segment["from"], realInfoTo, segment["departureTime"], segment["arrivalTime"], segment["freeSeats"], segment["price"])
+// moreAlternatives notes the alternatives left out, far shorter than
+// maxDescription.
+func moreAlternatives(n int) string {
+	return fmt.Sprintf("*%d more alternative routes are not shown*", n)
+}
 
+// segmentsDescription describes the segments within limit bytes, ending with
+// a note on the segments that do not fit.
+func segmentsDescription(segments []models.Segment, limit int) string {
+	var description strings.Builder
+	for i, segment := range segments {
+		line := fmt.Sprintf("**%s -> %s** (Departure: %s, Arrival: %s) \n *Free Seats: %d, Price: %.2f CZK, Seat: %s*\n",
+			segment.FromStationName, segment.ToStationName, segment.DepartureTime.Format("15:04"), segment.ArrivalTime.Format("15:04"), segment.FreeSeats, segment.Price, notifier.SeatName(segment))
+
+		// unless it is the last one, the segment leaves room for the note
+		room := limit
+		if i < len(segments)-1 {
+			room -= len(moreSegments(len(segments) - i - 1))
 		}
-
-		alternative := map[string]interface{}{
-			"name":   fmt.Sprintf("Alternative route with Total Price: %s CZK", totalPrice),
-			"value":  segmentsDescription,
-			"inline": false,
+		if description.Len()+len(line) > room {
+			description.WriteString(moreSegments(len(segments) - i))
+			break
 		}
-
-		alternatives = append(alternatives, alternative)
+		description.WriteString(line)
 	}
+	return description.String()
+}
 
-	payload := map[string]interface{}{
-		"content": "",
-		"embeds": []map[string]interface{}{
-			{
-				"title":  fmt.Sprintf("Alternative routes %s -> %s (%s)", routeInfo["from"], routeInfo["to"], routeInfo["departureDate"]),
-				"color":  3447003,
-				"fields": alternatives,
-				"footer": map[string]interface{}{
-					"text": fmt.Sprintf("Last updated at %s", time.Now().Format("15:04:05")),
-				},
-			},
-		},
-	}
+func moreSegments(n int) string {
+	return fmt.Sprintf("*...and %d more segments*\n", n)
+}
 
+func (s *DiscordService) post(ctx context.Context, webhookURL string, payload interface{}) error {
 	jsonPayload, err := json.Marshal(payload)
 	if err != nil {
-		s.logger.Fatal("Failed to marshal JSON payload", zap.Error(err))
-		return
+		return fmt.Errorf("failed to marshal JSON payload: %v", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhookURL, bytes.NewReader(jsonPayload))
+	if err != nil {
+		return err
 	}
+	req.Header.Set("Content-Type", "application/json")
 
-	resp, err := http.Post(webhookURL, "application/json", bytes.NewReader(jsonPayload))
+	resp, err := s.client.Do(req)
 	if err != nil {
-		s.logger.Fatal("Failed to send Discord notification", zap.Error(err))
-	} else {
-		defer resp.Body.Close()
-		if resp.StatusCode != http.StatusNoContent {
-			s.logger.Error("Failed to send Discord notification", zap.Int("status", resp.StatusCode))
+		return fmt.Errorf("failed to send Discord notification: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
+		deliveryErr := notifier.NewStatusError("Discord", resp)
+		if resp.StatusCode == http.StatusTooManyRequests {
+			// The body says how long to wait in fractional seconds, which is
+			// more precise than the Retry-After header.
+			var rateLimit struct {
+				RetryAfter float64 `json:"retry_after"`
+			}
+			if json.NewDecoder(resp.Body).Decode(&rateLimit) == nil && rateLimit.RetryAfter > 0 {
+				deliveryErr.RetryAfter = time.Duration(rateLimit.RetryAfter * float64(time.Second))
+			}
 		}
+		return deliveryErr
 	}
+	return nil
 }
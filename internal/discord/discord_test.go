@@ -0,0 +1,114 @@
+package discord
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bxxf/regiojet-watchdog/internal/models"
+	"go.uber.org/zap"
+)
+
+type embed struct {
+	Title       string `json:"title"`
+	Description string `json:"description"`
+	Fields      []struct {
+		Name  string `json:"name"`
+		Value string `json:"value"`
+	} `json:"fields"`
+	Footer struct {
+		Text string `json:"text"`
+	} `json:"footer"`
+}
+
+// length is what Discord counts towards the limit of the embed.
+func (e embed) length() int {
+	length := len(e.Title) + len(e.Description) + len(e.Footer.Text)
+	for _, field := range e.Fields {
+		length += len(field.Name) + len(field.Value)
+	}
+	return length
+}
+
+// newTestWebhook returns the URL of a webhook that collects the embeds posted
+// to it.
+func newTestWebhook(t *testing.T) (string, *[]embed) {
+	var embeds []embed
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload struct {
+			Embeds []embed `json:"embeds"`
+		}
+		json.NewDecoder(r.Body).Decode(&payload)
+		embeds = append(embeds, payload.Embeds...)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	t.Cleanup(server.Close)
+	return server.URL, &embeds
+}
+
+// alternatives returns n alternative routes of the given number of segments.
+func alternatives(n, segments int) []models.SegmentPath {
+	departure := time.Date(2026, 10, 17, 6, 0, 0, 0, time.UTC)
+	paths := make([]models.SegmentPath, n)
+	for i := range paths {
+		for k := 0; k < segments; k++ {
+			paths[i].Segments = append(paths[i].Segments, models.Segment{
+				FromStationName: fmt.Sprintf("Ostrava-Svinov %d", k),
+				ToStationName:   fmt.Sprintf("Ostrava-Svinov %d", k+1),
+				DepartureTime:   departure.Add(time.Duration(k) * time.Minute),
+				ArrivalTime:     departure.Add(time.Duration(k+1) * time.Minute),
+				FreeSeats:       2,
+				Price:           99,
+			})
+		}
+	}
+	return paths
+}
+
+func TestNotifyAlternativesWithinEmbedLimits(t *testing.T) {
+	cases := []struct {
+		paths, segments int
+		notShown        string
+	}{
+		{paths: 2, segments: 3},
+		{paths: 1, segments: 40, notShown: "more segments"},
+		{paths: 10, segments: 8, notShown: "more alternative routes are not shown"},
+		{paths: 10, segments: 40, notShown: "more alternative routes are not shown"},
+	}
+	for _, c := range cases {
+		webhookURL, embeds := newTestWebhook(t)
+		s := NewDiscordService(zap.NewNop())
+		if err := s.NotifyAlternatives(context.Background(), models.Watchdog{}, Target(webhookURL), alternatives(c.paths, c.segments)); err != nil {
+			t.Fatal(err)
+		}
+
+		if len(*embeds) != 1 {
+			t.Fatalf("%d alternatives of %d segments: posted %d embeds, want 1", c.paths, c.segments, len(*embeds))
+		}
+		e := (*embeds)[0]
+		if e.length() > maxEmbedLength {
+			t.Errorf("%d alternatives of %d segments: posted an embed of %d bytes, more than %d", c.paths, c.segments, e.length(), maxEmbedLength)
+		}
+		if len(e.Description) > maxDescription {
+			t.Errorf("%d alternatives of %d segments: posted a description of %d bytes, more than %d", c.paths, c.segments, len(e.Description), maxDescription)
+		}
+		text := e.Description
+		for _, field := range e.Fields {
+			if len(field.Value) > maxFieldValue {
+				t.Errorf("%d alternatives of %d segments: posted a field of %d bytes, more than %d", c.paths, c.segments, len(field.Value), maxFieldValue)
+			}
+			text += field.Value
+		}
+		if c.notShown != "" && !strings.Contains(text, c.notShown) {
+			t.Errorf("%d alternatives of %d segments: the embed does not note that %s", c.paths, c.segments, c.notShown)
+		}
+		if !strings.HasPrefix(e.Footer.Text, "Last updated at") {
+			t.Errorf("%d alternatives of %d segments: the embed lost its footer", c.paths, c.segments)
+		}
+	}
+}
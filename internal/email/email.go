@@ -0,0 +1,275 @@
+package email
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"strings"
+	"time"
+
+	"github.com/bxxf/regiojet-watchdog/internal/config"
+	"github.com/bxxf/regiojet-watchdog/internal/models"
+	"github.com/bxxf/regiojet-watchdog/internal/notifier"
+	"go.uber.org/zap"
+)
+
+const targetType = "email"
+
+// EmailService sends notifications as multipart text and HTML emails over
+// SMTP, using implicit TLS, STARTTLS or a plain connection as configured.
+type EmailService struct {
+	logger *zap.Logger
+	config config.Config
+	// rootCAs verifies the certificate of the SMTP server, the system roots
+	// if nil.
+	rootCAs *x509.CertPool
+}
+
+func NewEmailService(logger *zap.Logger, config config.Config) *EmailService {
+	return &EmailService{
+		logger: logger,
+		config: config,
+	}
+}
+
+func (s *EmailService) Type() string {
+	return targetType
+}
+
+func (s *EmailService) Validate(target models.NotificationTarget) error {
+	if s.config.SMTPHost == "" || s.config.SMTPFrom == "" {
+		return errors.New("email notifications are not configured, SMTP_HOST and SMTP_FROM must be set")
+	}
+	if _, err := mail.ParseAddressList(target.Settings["to"]); err != nil {
+		return errors.New("email target requires a to setting with comma separated email addresses")
+	}
+	return nil
+}
+
+func (s *EmailService) NotifyFreeSeats(ctx context.Context, watchdog models.Watchdog, target models.NotificationTarget, freeSeats models.FreeSeatsResponse, routeDetails models.RouteDetails) error {
+	if routeDetails.FreeSeatsCount == 0 {
+		return nil
+	}
+
+	data := freeSeatsData{
+		Route:     routeDetails,
+		Departure: notifier.Clock(routeDetails.DepartureTime),
+		Arrival:   notifier.Clock(routeDetails.ArrivalTime),
+		Date:      notifier.Date(routeDetails.DepartureTime),
+		Vehicles:  notifier.CountFreeSeats(freeSeats),
+	}
+	subject := fmt.Sprintf("Tickets available (%s -> %s) - %s -> %s [%s]",
+		routeDetails.DepartureCityName, routeDetails.ArrivalCityName, data.Departure, data.Arrival, data.Date)
+
+	return s.send(ctx, target, subject, "free_seats", data)
+}
+
+func (s *EmailService) NotifySoldOut(ctx context.Context, watchdog models.Watchdog, target models.NotificationTarget, routeDetails models.RouteDetails) error {
+	data := freeSeatsData{
+		Route:     routeDetails,
+		Departure: notifier.Clock(routeDetails.DepartureTime),
+		Arrival:   notifier.Clock(routeDetails.ArrivalTime),
+		Date:      notifier.Date(routeDetails.DepartureTime),
+	}
+	subject := fmt.Sprintf("Sold out (%s -> %s) - %s -> %s [%s]",
+		routeDetails.DepartureCityName, routeDetails.ArrivalCityName, data.Departure, data.Arrival, data.Date)
+
+	return s.send(ctx, target, subject, "sold_out", data)
+}
+
+func (s *EmailService) NotifyAlternatives(ctx context.Context, watchdog models.Watchdog, target models.NotificationTarget, paths []models.SegmentPath) error {
+	if len(paths) == 0 {
+		return nil
+	}
+
+	data := alternativesData{
+		Alternatives: paths,
+		UpdatedAt:    time.Now().Format("15:04:05"),
+	}
+	data.From, data.To, data.Date = notifier.Journey(paths)
+	subject := fmt.Sprintf("Alternative routes %s -> %s (%s)", data.From, data.To, data.Date)
+
+	return s.send(ctx, target, subject, "alternatives", data)
+}
+
+func (s *EmailService) send(ctx context.Context, target models.NotificationTarget, subject, templateName string, data interface{}) error {
+	recipients, err := mail.ParseAddressList(target.Settings["to"])
+	if err != nil {
+		return fmt.Errorf("invalid email recipients: %v", err)
+	}
+
+	var text, html bytes.Buffer
+	if err := textTemplates.ExecuteTemplate(&text, templateName, data); err != nil {
+		return fmt.Errorf("failed to render email: %v", err)
+	}
+	if err := htmlTemplates.ExecuteTemplate(&html, templateName, data); err != nil {
+		return fmt.Errorf("failed to render email: %v", err)
+	}
+
+	message, err := s.buildMessage(recipients, subject, text.Bytes(), html.Bytes())
+	if err != nil {
+		return err
+	}
+
+	to := make([]string, 0, len(recipients))
+	for _, recipient := range recipients {
+		to = append(to, recipient.Address)
+	}
+	return s.deliver(ctx, to, message)
+}
+
+func (s *EmailService) buildMessage(recipients []*mail.Address, subject string, text, html []byte) ([]byte, error) {
+	from, err := mail.ParseAddress(s.config.SMTPFrom)
+	if err != nil {
+		return nil, fmt.Errorf("invalid SMTP_FROM: %v", err)
+	}
+
+	to := make([]string, 0, len(recipients))
+	for _, recipient := range recipients {
+		to = append(to, recipient.String())
+	}
+
+	var message bytes.Buffer
+	body := multipart.NewWriter(&message)
+
+	fmt.Fprintf(&message, "From: %s\r\n", from.String())
+	fmt.Fprintf(&message, "To: %s\r\n", strings.Join(to, ", "))
+	fmt.Fprintf(&message, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&message, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&message, "Message-ID: <%s@%s>\r\n", randomID(), domain(from.Address))
+	fmt.Fprintf(&message, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&message, "Content-Type: multipart/alternative; boundary=%q\r\n\r\n", body.Boundary())
+
+	for _, part := range []struct {
+		contentType string
+		content     []byte
+	}{
+		{"text/plain; charset=utf-8", text},
+		{"text/html; charset=utf-8", html},
+	} {
+		w, err := body.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		qp := quotedprintable.NewWriter(w)
+		if _, err := qp.Write(part.content); err != nil {
+			return nil, err
+		}
+		if err := qp.Close(); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := body.Close(); err != nil {
+		return nil, err
+	}
+	return message.Bytes(), nil
+}
+
+func (s *EmailService) deliver(ctx context.Context, to []string, message []byte) error {
+	host := s.config.SMTPHost
+	address := net.JoinHostPort(host, s.config.SMTPPort)
+	tlsConfig := &tls.Config{ServerName: host, RootCAs: s.rootCAs}
+
+	dialer := &net.Dialer{Timeout: 30 * time.Second}
+	var conn net.Conn
+	var err error
+	if s.config.SMTPTLS == "tls" {
+		conn, err = (&tls.Dialer{NetDialer: dialer, Config: tlsConfig}).DialContext(ctx, "tcp", address)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", address)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to connect to SMTP server: %v", err)
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	client, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("failed to connect to SMTP server: %v", err)
+	}
+	defer client.Close()
+
+	if s.config.SMTPTLS == "starttls" {
+		if err := client.StartTLS(tlsConfig); err != nil {
+			return fmt.Errorf("failed to start TLS: %v", err)
+		}
+	}
+
+	if s.config.SMTPUsername != "" {
+		auth := smtp.PlainAuth("", s.config.SMTPUsername, s.config.SMTPPassword, host)
+		if err := client.Auth(auth); err != nil {
+			return smtpError("failed to authenticate to SMTP server", err)
+		}
+	}
+
+	from, err := mail.ParseAddress(s.config.SMTPFrom)
+	if err != nil {
+		return fmt.Errorf("invalid SMTP_FROM: %v", err)
+	}
+	if err := client.Mail(from.Address); err != nil {
+		return smtpError("failed to send email", err)
+	}
+	for _, recipient := range to {
+		if err := client.Rcpt(recipient); err != nil {
+			return smtpError("failed to send email to "+recipient, err)
+		}
+	}
+
+	w, err := client.Data()
+	if err != nil {
+		return smtpError("failed to send email", err)
+	}
+	if _, err := w.Write(message); err != nil {
+		return smtpError("failed to send email", err)
+	}
+	if err := w.Close(); err != nil {
+		return smtpError("failed to send email", err)
+	}
+
+	return client.Quit()
+}
+
+// smtpError returns the error of a failed SMTP command. A permanent
+// negative reply (5xx) is a rejected DeliveryError, which is not retried.
+func smtpError(message string, err error) error {
+	var reply *textproto.Error
+	if errors.As(err, &reply) && reply.Code >= 500 && reply.Code < 600 {
+		return &notifier.DeliveryError{
+			Rejected: true,
+			Message:  fmt.Sprintf("%s: %v", message, err),
+		}
+	}
+	return fmt.Errorf("%s: %v", message, err)
+}
+
+func randomID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func domain(address string) string {
+	if i := strings.LastIndex(address, "@"); i >= 0 {
+		return address[i+1:]
+	}
+	return "localhost"
+}
@@ -0,0 +1,256 @@
+package email
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/http/httptest"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/bxxf/regiojet-watchdog/internal/config"
+	"github.com/bxxf/regiojet-watchdog/internal/models"
+	"github.com/bxxf/regiojet-watchdog/internal/notifier"
+	"go.uber.org/zap"
+)
+
+// received is a message delivered to the sink.
+type received struct {
+	tls  bool
+	from string
+	to   []string
+	data string
+}
+
+// sink is a local SMTP server speaking just enough SMTP for the client of
+// net/smtp, in the TLS mode of SMTP_TLS.
+type sink struct {
+	mode      string
+	tlsConfig *tls.Config
+	// reject is the reply to RCPT TO, empty to accept every recipient.
+	reject string
+
+	mu       sync.Mutex
+	messages []received
+}
+
+// newSink starts a sink and returns it with its port and the roots that
+// verify its certificate.
+func newSink(t *testing.T, mode, reject string) (*sink, string, *x509.CertPool) {
+	// the certificate of httptest is valid for 127.0.0.1
+	server := httptest.NewUnstartedServer(nil)
+	server.StartTLS()
+	rootCAs := x509.NewCertPool()
+	rootCAs.AddCert(server.Certificate())
+	s := &sink{mode: mode, tlsConfig: &tls.Config{Certificates: server.TLS.Certificates}, reject: reject}
+	server.Close()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mode == "tls" {
+		listener = tls.NewListener(listener, s.tlsConfig)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+
+	_, port, _ := net.SplitHostPort(listener.Addr().String())
+	return s, port, rootCAs
+}
+
+func (s *sink) serve(conn net.Conn) {
+	defer func() { conn.Close() }()
+
+	text := textproto.NewConn(conn)
+	message := received{tls: s.mode == "tls"}
+	text.PrintfLine("220 localhost ESMTP sink")
+	for {
+		line, err := text.ReadLine()
+		if err != nil {
+			return
+		}
+		verb, argument, _ := strings.Cut(line, " ")
+
+		switch strings.ToUpper(verb) {
+		case "EHLO", "HELO":
+			if s.mode == "starttls" && !message.tls {
+				text.PrintfLine("250-localhost\r\n250 STARTTLS")
+			} else {
+				text.PrintfLine("250 localhost")
+			}
+		case "STARTTLS":
+			text.PrintfLine("220 Ready to start TLS")
+			tlsConn := tls.Server(conn, s.tlsConfig)
+			if err := tlsConn.Handshake(); err != nil {
+				return
+			}
+			conn = tlsConn
+			text = textproto.NewConn(conn)
+			message.tls = true
+		case "MAIL":
+			message.from = strings.Trim(strings.TrimPrefix(argument, "FROM:"), "<>")
+			text.PrintfLine("250 OK")
+		case "RCPT":
+			if s.reject != "" {
+				text.PrintfLine(s.reject)
+				continue
+			}
+			message.to = append(message.to, strings.Trim(strings.TrimPrefix(argument, "TO:"), "<>"))
+			text.PrintfLine("250 OK")
+		case "DATA":
+			text.PrintfLine("354 End data with <CR><LF>.<CR><LF>")
+			data, err := text.ReadDotBytes()
+			if err != nil {
+				return
+			}
+			message.data = string(data)
+			s.mu.Lock()
+			s.messages = append(s.messages, message)
+			s.mu.Unlock()
+			text.PrintfLine("250 OK")
+		case "QUIT":
+			text.PrintfLine("221 Bye")
+			return
+		default:
+			text.PrintfLine("502 Command not implemented")
+		}
+	}
+}
+
+func (s *sink) received() []received {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]received(nil), s.messages...)
+}
+
+// newTestService returns a service sending to the sink on the port.
+func newTestService(mode, port string, rootCAs *x509.CertPool) *EmailService {
+	s := NewEmailService(zap.NewNop(), config.Config{
+		SMTPHost: "127.0.0.1",
+		SMTPPort: port,
+		SMTPFrom: "RegioJet Watchdog <watchdog@example.com>",
+		SMTPTLS:  mode,
+	})
+	s.rootCAs = rootCAs
+	return s
+}
+
+var (
+	target       = models.NotificationTarget{Type: targetType, Settings: map[string]string{"to": "jane@example.com, John <john@example.com>"}}
+	routeDetails = models.RouteDetails{
+		FreeSeatsCount:    2,
+		DepartureCityName: "Praha",
+		ArrivalCityName:   "Havířov",
+		TravelTime:        "3:46 h",
+		DepartureTime:     "2026-10-17T06:01:00+02:00",
+		ArrivalTime:       "2026-10-17T09:47:00+02:00",
+	}
+	freeSeats = models.FreeSeatsResponse{{Vehicles: []models.Vehicle{{
+		VehicleNumber: 3,
+		FreeSeats:     []models.FreeSeat{{Index: 12, SeatClass: "C0"}, {Index: 14, SeatClass: "C0"}},
+	}}}}
+)
+
+func TestNotifyFreeSeatsSendsMultipartEmail(t *testing.T) {
+	for _, mode := range []string{"none", "starttls", "tls"} {
+		sink, port, rootCAs := newSink(t, mode, "")
+		s := newTestService(mode, port, rootCAs)
+		if err := s.NotifyFreeSeats(context.Background(), models.Watchdog{}, target, freeSeats, routeDetails); err != nil {
+			t.Fatalf("%s: %v", mode, err)
+		}
+
+		messages := sink.received()
+		if len(messages) != 1 {
+			t.Fatalf("%s: received %d messages, want 1", mode, len(messages))
+		}
+		m := messages[0]
+		if m.tls != (mode != "none") {
+			t.Errorf("%s: sent with TLS %v", mode, m.tls)
+		}
+		if m.from != "watchdog@example.com" || strings.Join(m.to, ",") != "jane@example.com,john@example.com" {
+			t.Errorf("%s: sent from %s to %v", mode, m.from, m.to)
+		}
+
+		message, err := mail.ReadMessage(strings.NewReader(m.data))
+		if err != nil {
+			t.Fatalf("%s: %v", mode, err)
+		}
+		subject, err := new(mime.WordDecoder).DecodeHeader(message.Header.Get("Subject"))
+		if err != nil || subject != "Tickets available (Praha -> Havířov) - 06:01 -> 09:47 [17.10.2026]" {
+			t.Errorf("%s: got subject %q, %v", mode, subject, err)
+		}
+		if from := message.Header.Get("From"); from != `"RegioJet Watchdog" <watchdog@example.com>` {
+			t.Errorf("%s: got From %s", mode, from)
+		}
+		if to := message.Header.Get("To"); to != `<jane@example.com>, "John" <john@example.com>` {
+			t.Errorf("%s: got To %s", mode, to)
+		}
+		if message.Header.Get("MIME-Version") != "1.0" || message.Header.Get("Message-ID") == "" || message.Header.Get("Date") == "" {
+			t.Errorf("%s: got header %v", mode, message.Header)
+		}
+
+		mediaType, params, err := mime.ParseMediaType(message.Header.Get("Content-Type"))
+		if err != nil || mediaType != "multipart/alternative" {
+			t.Fatalf("%s: got content type %s, %v", mode, mediaType, err)
+		}
+		parts := multipart.NewReader(message.Body, params["boundary"])
+		for _, want := range []struct{ contentType, content string }{
+			{"text/plain; charset=utf-8", "3        C0     2"},
+			{"text/html; charset=utf-8", "<tr><td>3</td><td>C0</td><td>2</td></tr>"},
+		} {
+			part, err := parts.NextPart()
+			if err != nil {
+				t.Fatalf("%s: %v", mode, err)
+			}
+			// the quoted-printable encoding is removed by the reader
+			content, _ := io.ReadAll(part)
+			if part.Header.Get("Content-Type") != want.contentType || !strings.Contains(string(content), want.content) || !strings.Contains(string(content), "Havířov") {
+				t.Errorf("%s: got %s part %q, want %s with %q", mode, part.Header.Get("Content-Type"), content, want.contentType, want.content)
+			}
+		}
+		if _, err := parts.NextPart(); err != io.EOF {
+			t.Errorf("%s: got %v after the HTML part, want no more parts", mode, err)
+		}
+	}
+}
+
+func TestRejectedEmail(t *testing.T) {
+	cases := []struct {
+		reply     string
+		permanent bool
+	}{
+		{"550 5.1.1 No such user", true},
+		{"451 4.3.0 Try again later", false},
+	}
+	for _, c := range cases {
+		_, port, rootCAs := newSink(t, "none", c.reply)
+		s := newTestService("none", port, rootCAs)
+		err := s.NotifyFreeSeats(context.Background(), models.Watchdog{}, target, freeSeats, routeDetails)
+		if err == nil {
+			t.Fatalf("%s: sent the email", c.reply)
+		}
+
+		var deliveryErr *notifier.DeliveryError
+		if permanent := errors.As(err, &deliveryErr) && deliveryErr.Permanent(); permanent != c.permanent {
+			t.Errorf("%s: got %v, want permanent %v", c.reply, err, c.permanent)
+		}
+	}
+}
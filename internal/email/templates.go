@@ -0,0 +1,97 @@
+package email
+
+import (
+	htmltemplate "html/template"
+	texttemplate "text/template"
+
+	"github.com/bxxf/regiojet-watchdog/internal/models"
+	"github.com/bxxf/regiojet-watchdog/internal/notifier"
+)
+
+type freeSeatsData struct {
+	Route     models.RouteDetails
+	Departure string
+	Arrival   string
+	Date      string
+	Vehicles  []notifier.VehicleSeats
+}
+
+type alternativesData struct {
+	From         string
+	To           string
+	Date         string
+	Alternatives []models.SegmentPath
+	UpdatedAt    string
+}
+
+var textTemplates = texttemplate.Must(texttemplate.New("").Funcs(texttemplate.FuncMap{"seatName": notifier.SeatName}).Parse(`
+{{- define "free_seats" -}}
+Tickets available ({{.Route.DepartureCityName}} -> {{.Route.ArrivalCityName}}) - {{.Departure}} -> {{.Arrival}} [{{.Date}}]
+
+Travel Time: {{.Route.TravelTime}}, Free seats count: {{.Route.FreeSeatsCount}}
+
+Vehicle  Class  Free seats
+{{range .Vehicles}}{{printf "%-8d %-6s %d" .VehicleNumber .SeatClass .FreeSeats}}
+{{end}}
+Price From: {{printf "%.0f" .Route.PriceFrom}}CZK, Price To: {{printf "%.0f" .Route.PriceTo}}CZK
+{{end}}
+
+{{- define "sold_out" -}}
+Sold out ({{.Route.DepartureCityName}} -> {{.Route.ArrivalCityName}}) - {{.Departure}} -> {{.Arrival}} [{{.Date}}]
+
+There are no free seats left, you will be notified when some appear again.
+{{end}}
+
+{{- define "alternatives" -}}
+Alternative routes {{.From}} -> {{.To}} ({{.Date}})
+{{range .Alternatives}}
+Alternative route with Total Price: {{printf "%.2f" .TotalPrice}} CZK, Seat Changes: {{.SeatChanges}}, Train Changes: {{.Transfers}}
+{{range .Segments}}  {{.FromStationName}} -> {{.ToStationName}} (Departure: {{.DepartureTime.Format "15:04"}}, Arrival: {{.ArrivalTime.Format "15:04"}}) - Free Seats: {{.FreeSeats}}, Price: {{printf "%.2f" .Price}} CZK, Seat: {{seatName .}}
+{{end}}{{end}}
+Last updated at {{.UpdatedAt}}
+{{end}}
+`))
+
+var htmlTemplates = htmltemplate.Must(htmltemplate.New("").Funcs(htmltemplate.FuncMap{"seatName": notifier.SeatName}).Parse(`
+{{- define "free_seats" -}}
+<!DOCTYPE html>
+<html>
+<body style="font-family: sans-serif;">
+<h2>Tickets available ({{.Route.DepartureCityName}} &rarr; {{.Route.ArrivalCityName}}) - {{.Departure}} &rarr; {{.Arrival}} [{{.Date}}]</h2>
+<p>Travel Time: {{.Route.TravelTime}}, Free seats count: {{.Route.FreeSeatsCount}}</p>
+<table cellpadding="4" cellspacing="0" border="1" style="border-collapse: collapse;">
+<tr><th>Vehicle</th><th>Class</th><th>Free seats</th></tr>
+{{range .Vehicles}}<tr><td>{{.VehicleNumber}}</td><td>{{.SeatClass}}</td><td>{{.FreeSeats}}</td></tr>
+{{end}}</table>
+<p><small>Price From: {{printf "%.0f" .Route.PriceFrom}}CZK, Price To: {{printf "%.0f" .Route.PriceTo}}CZK</small></p>
+</body>
+</html>
+{{end}}
+
+{{- define "sold_out" -}}
+<!DOCTYPE html>
+<html>
+<body style="font-family: sans-serif;">
+<h2>Sold out ({{.Route.DepartureCityName}} &rarr; {{.Route.ArrivalCityName}}) - {{.Departure}} &rarr; {{.Arrival}} [{{.Date}}]</h2>
+<p>There are no free seats left, you will be notified when some appear again.</p>
+</body>
+</html>
+{{end}}
+
+{{- define "alternatives" -}}
+<!DOCTYPE html>
+<html>
+<body style="font-family: sans-serif;">
+<h2>Alternative routes {{.From}} &rarr; {{.To}} ({{.Date}})</h2>
+{{range .Alternatives}}
+<h3>Alternative route with Total Price: {{printf "%.2f" .TotalPrice}} CZK, Seat Changes: {{.SeatChanges}}, Train Changes: {{.Transfers}}</h3>
+<table cellpadding="4" cellspacing="0" border="1" style="border-collapse: collapse;">
+<tr><th>From</th><th>To</th><th>Departure</th><th>Arrival</th><th>Free seats</th><th>Price</th><th>Seat</th></tr>
+{{range .Segments}}<tr><td>{{.FromStationName}}</td><td>{{.ToStationName}}</td><td>{{.DepartureTime.Format "15:04"}}</td><td>{{.ArrivalTime.Format "15:04"}}</td><td>{{.FreeSeats}}</td><td>{{printf "%.2f" .Price}} CZK</td><td>{{seatName .}}</td></tr>
+{{end}}</table>
+{{end}}
+<p><small>Last updated at {{.UpdatedAt}}</small></p>
+</body>
+</html>
+{{end}}
+`))
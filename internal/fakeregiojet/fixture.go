@@ -0,0 +1,131 @@
+package fakeregiojet
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+//go:embed fixtures/network.json
+var defaultFixture []byte
+
+// Fixture describes the stations and trains of the fake API. Every train runs
+// every day.
+type Fixture struct {
+	Stations []Station `json:"stations"`
+	Trains   []Train   `json:"trains"`
+}
+
+type Station struct {
+	ID   int64  `json:"id"`
+	Name string `json:"name"`
+	City string `json:"city"`
+}
+
+type Train struct {
+	ID int64 `json:"id"`
+	// PricePerLeg is the price of a seat of the cheapest class between two
+	// neighbouring stops.
+	PricePerLeg float64     `json:"pricePerLeg"`
+	Stops       []TrainStop `json:"stops"`
+	Vehicles    []Vehicle   `json:"vehicles"`
+	Bookings    []Booking   `json:"bookings"`
+}
+
+// TrainStop times are "15:04" times of day.
+type TrainStop struct {
+	StationID int64  `json:"stationId"`
+	Arrival   string `json:"arrival,omitempty"`
+	Departure string `json:"departure,omitempty"`
+	Platform  string `json:"platform,omitempty"`
+}
+
+type Vehicle struct {
+	Number    int    `json:"number"`
+	SeatClass string `json:"seatClass"`
+	Seats     int    `json:"seats"`
+}
+
+// Booking occupies seats of a vehicle from stop From to stop To, given as
+// indexes into the stops of the train. No seats means all seats of the
+// vehicle, and To 0 means the last stop. A booking with ReleaseAfter is
+// cancelled that long after the fake server started, which frees its seats.
+type Booking struct {
+	Vehicle      int      `json:"vehicle"`
+	Seats        []int    `json:"seats,omitempty"`
+	From         int      `json:"from"`
+	To           int      `json:"to,omitempty"`
+	ReleaseAfter Duration `json:"releaseAfter,omitempty"`
+}
+
+// Duration is a time.Duration given as a string such as "2m" in JSON.
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var value string
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		return err
+	}
+	*d = Duration(duration)
+	return nil
+}
+
+// DefaultFixture returns the fixture bundled with the package, a single line
+// from Prague to Havířov.
+func DefaultFixture() *Fixture {
+	fixture, err := LoadFixture(defaultFixture)
+	if err != nil {
+		panic(fmt.Sprintf("invalid bundled fixture: %v", err))
+	}
+	return fixture
+}
+
+// LoadFixture parses and validates a fixture.
+func LoadFixture(data []byte) (*Fixture, error) {
+	var fixture Fixture
+	if err := json.Unmarshal(data, &fixture); err != nil {
+		return nil, err
+	}
+
+	stations := make(map[int64]bool)
+	for _, station := range fixture.Stations {
+		stations[station.ID] = true
+	}
+	for t := range fixture.Trains {
+		train := &fixture.Trains[t]
+		if len(train.Stops) < 2 {
+			return nil, fmt.Errorf("train %d has less than two stops", train.ID)
+		}
+		for i, stop := range train.Stops {
+			if !stations[stop.StationID] {
+				return nil, fmt.Errorf("train %d stops at unknown station %d", train.ID, stop.StationID)
+			}
+			if i > 0 && !validTime(stop.Arrival) {
+				return nil, fmt.Errorf("train %d has an invalid arrival at stop %d", train.ID, i)
+			}
+			if i < len(train.Stops)-1 && !validTime(stop.Departure) {
+				return nil, fmt.Errorf("train %d has an invalid departure at stop %d", train.ID, i)
+			}
+		}
+		for i := range train.Bookings {
+			booking := &train.Bookings[i]
+			if booking.To == 0 {
+				booking.To = len(train.Stops) - 1
+			}
+			if booking.From < 0 || booking.From >= booking.To || booking.To >= len(train.Stops) {
+				return nil, fmt.Errorf("train %d has a booking with invalid stops", train.ID)
+			}
+		}
+	}
+	return &fixture, nil
+}
+
+func validTime(value string) bool {
+	_, err := time.Parse("15:04", value)
+	return err == nil
+}
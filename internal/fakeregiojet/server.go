@@ -0,0 +1,344 @@
+// Package fakeregiojet serves a fake RegioJet API from fixtures, so the
+// watchdog can be developed and demoed offline. It answers the requests the
+// train and constants clients make, and frees booked seats over time.
+package fakeregiojet
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bxxf/regiojet-watchdog/internal/constants"
+	"github.com/bxxf/regiojet-watchdog/internal/models"
+)
+
+// Route IDs are the train ID followed by the date it runs on, e.g.
+// 101020261016 for train 1010 on 16 October 2026.
+const dateDigits = 100000000
+
+type Server struct {
+	fixture  *Fixture
+	stations map[int64]Station
+	started  time.Time
+	mux      *http.ServeMux
+}
+
+// NewServer returns the fake API for the fixture, with its base URL at the
+// root path. Bookings are released relative to the time it is created.
+func NewServer(fixture *Fixture) *Server {
+	s := &Server{
+		fixture:  fixture,
+		stations: make(map[int64]Station),
+		started:  time.Now(),
+		mux:      http.NewServeMux(),
+	}
+	for _, station := range fixture.Stations {
+		s.stations[station.ID] = station
+	}
+
+	s.mux.HandleFunc("/routes/search/simple", s.searchRoutesHandler)
+	s.mux.HandleFunc("/routes/", s.routeHandler)
+	s.mux.HandleFunc("/consts/timetables/", s.timetableHandler)
+	s.mux.HandleFunc("/consts/locations", s.locationsHandler)
+	return s
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+func (s *Server) searchRoutesHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	fromID, errFrom := strconv.ParseInt(query.Get("fromLocationId"), 10, 64)
+	toID, errTo := strconv.ParseInt(query.Get("toLocationId"), 10, 64)
+	date, errDate := time.ParseInLocation("2006-01-02", query.Get("departureDate"), time.Local)
+	if errFrom != nil || errTo != nil || errDate != nil {
+		writeError(w, http.StatusBadRequest, "Invalid search parameters")
+		return
+	}
+
+	routes := []models.TrainTicket{}
+	for i := range s.fixture.Trains {
+		train := &s.fixture.Trains[i]
+		from, to := stopIndex(train, fromID), stopIndex(train, toID)
+		if from < 0 || to <= from {
+			continue
+		}
+
+		departure, arrival := times(train, date, from, to)
+		fare := price(train, from, to)
+		routes = append(routes, models.TrainTicket{
+			ID:             strconv.FormatInt(routeID(train, date), 10),
+			DepartureTime:  departure.Format(time.RFC3339),
+			ArrivalTime:    arrival.Format(time.RFC3339),
+			FreeSeatsCount: s.freeSeatsCount(train, from, to),
+			PriceFrom:      fare,
+			PriceTo:        fare,
+			TravelTime:     travelTime(departure, arrival),
+			VehicleTypes:   []string{"TRAIN"},
+		})
+	}
+	sort.Slice(routes, func(i, j int) bool {
+		return routes[i].DepartureTime < routes[j].DepartureTime
+	})
+
+	writeJSON(w, models.Response{Routes: routes})
+}
+
+// routeHandler serves /routes/{id}/simple and /routes/{id}/freeSeats.
+func (s *Server) routeHandler(w http.ResponseWriter, r *http.Request) {
+	id, action, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/routes/"), "/")
+	switch {
+	case action == "simple" && r.Method == http.MethodGet:
+		s.routeDetails(w, r, id)
+	case action == "freeSeats" && r.Method == http.MethodPost:
+		s.freeSeats(w, r)
+	default:
+		writeError(w, http.StatusNotFound, "Not found")
+	}
+}
+
+func (s *Server) routeDetails(w http.ResponseWriter, r *http.Request, id string) {
+	train, date, ok := s.route(id)
+	if !ok {
+		writeError(w, http.StatusNotFound, "Route not found")
+		return
+	}
+
+	fromID, _ := strconv.ParseInt(r.URL.Query().Get("fromStationId"), 10, 64)
+	toID, _ := strconv.ParseInt(r.URL.Query().Get("toStationId"), 10, 64)
+	from, to := stopIndex(train, fromID), stopIndex(train, toID)
+	if from < 0 || to <= from {
+		writeError(w, http.StatusBadRequest, "The route does not go between the stations")
+		return
+	}
+
+	departure, arrival := times(train, date, from, to)
+	fare := price(train, from, to)
+	details := models.RouteDetailsResponse{
+		PriceFrom:         fare,
+		PriceTo:           fare,
+		FreeSeatsCount:    s.freeSeatsCount(train, from, to),
+		DepartureCityName: s.stations[fromID].City,
+		ArrivalCityName:   s.stations[toID].City,
+		DepartureTime:     departure.Format(time.RFC3339),
+		ArrivalTime:       arrival.Format(time.RFC3339),
+	}
+	details.Sections = append(details.Sections, struct {
+		TravelTime string `json:"travelTime"`
+	}{TravelTime: travelTime(departure, arrival)})
+
+	writeJSON(w, details)
+}
+
+type freeSeatsRequest struct {
+	Sections []struct {
+		SectionID     int64 `json:"sectionId"`
+		FromStationID int64 `json:"fromStationId"`
+		ToStationID   int64 `json:"toStationId"`
+	} `json:"sections"`
+	SeatClass string `json:"seatClass"`
+}
+
+func (s *Server) freeSeats(w http.ResponseWriter, r *http.Request) {
+	var body freeSeatsRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, "Failed to parse request body")
+		return
+	}
+
+	response := models.FreeSeatsResponse{}
+	for _, section := range body.Sections {
+		train, _, ok := s.route(strconv.FormatInt(section.SectionID, 10))
+		if !ok {
+			writeError(w, http.StatusNotFound, "Route not found")
+			return
+		}
+		from, to := stopIndex(train, section.FromStationID), stopIndex(train, section.ToStationID)
+		if from < 0 || to <= from {
+			writeError(w, http.StatusBadRequest, "The route does not go between the stations")
+			return
+		}
+
+		vehicles := []models.Vehicle{}
+		for _, vehicle := range train.Vehicles {
+			if body.SeatClass != "" && vehicle.SeatClass != body.SeatClass {
+				continue
+			}
+			freeSeats := []models.FreeSeat{}
+			for _, seat := range s.freeSeatsOf(train, vehicle, from, to) {
+				freeSeats = append(freeSeats, models.FreeSeat{Index: seat, SeatClass: vehicle.SeatClass})
+			}
+			vehicles = append(vehicles, models.Vehicle{
+				FreeSeats:     freeSeats,
+				SeatClasses:   []string{vehicle.SeatClass},
+				VehicleNumber: vehicle.Number,
+			})
+		}
+		response = append(response, models.Section{SectionId: section.SectionID, Vehicles: vehicles})
+	}
+
+	writeJSON(w, response)
+}
+
+func (s *Server) timetableHandler(w http.ResponseWriter, r *http.Request) {
+	train, _, ok := s.route(strings.TrimPrefix(r.URL.Path, "/consts/timetables/"))
+	if !ok {
+		writeError(w, http.StatusNotFound, "Timetable not found")
+		return
+	}
+
+	timetable := models.TimetableResponse{
+		ConnectionID: int(train.ID),
+		FromCityName: s.stations[train.Stops[0].StationID].City,
+		ToCityName:   s.stations[train.Stops[len(train.Stops)-1].StationID].City,
+	}
+	for i, stop := range train.Stops {
+		timetable.Stations = append(timetable.Stations, models.Stop{
+			StationID: int(stop.StationID),
+			Index:     i,
+			Departure: timetableTime(stop.Departure),
+			Arrival:   timetableTime(stop.Arrival),
+			Symbols:   []string{},
+			Platform:  stop.Platform,
+		})
+	}
+
+	writeJSON(w, timetable)
+}
+
+func (s *Server) locationsHandler(w http.ResponseWriter, r *http.Request) {
+	var cities []constants.City
+	byName := make(map[string]int)
+	for _, station := range s.fixture.Stations {
+		i, ok := byName[station.City]
+		if !ok {
+			i = len(cities)
+			byName[station.City] = i
+			cities = append(cities, constants.City{})
+		}
+		cities[i].Stations = append(cities[i].Stations, constants.Station{
+			ID:           station.ID,
+			FullName:     station.Name,
+			StationTypes: []string{"TRAIN_STATION"},
+		})
+	}
+
+	writeJSON(w, []constants.Country{{Cities: cities}})
+}
+
+// route returns the train and the date of the route ID.
+func (s *Server) route(id string) (*Train, time.Time, bool) {
+	n, err := strconv.ParseInt(id, 10, 64)
+	if err != nil {
+		return nil, time.Time{}, false
+	}
+	date, err := time.ParseInLocation("20060102", strconv.FormatInt(n%dateDigits, 10), time.Local)
+	if err != nil {
+		return nil, time.Time{}, false
+	}
+
+	for i := range s.fixture.Trains {
+		if s.fixture.Trains[i].ID == n/dateDigits {
+			return &s.fixture.Trains[i], date, true
+		}
+	}
+	return nil, time.Time{}, false
+}
+
+func routeID(train *Train, date time.Time) int64 {
+	n, _ := strconv.ParseInt(date.Format("20060102"), 10, 64)
+	return train.ID*dateDigits + n
+}
+
+func stopIndex(train *Train, stationID int64) int {
+	for i, stop := range train.Stops {
+		if stop.StationID == stationID {
+			return i
+		}
+	}
+	return -1
+}
+
+// times returns the departure from stop from and the arrival at stop to.
+func times(train *Train, date time.Time, from, to int) (time.Time, time.Time) {
+	return atTime(date, train.Stops[from].Departure), atTime(date, train.Stops[to].Arrival)
+}
+
+func price(train *Train, from, to int) float64 {
+	return train.PricePerLeg * float64(to-from)
+}
+
+func (s *Server) freeSeatsCount(train *Train, from, to int) int {
+	count := 0
+	for _, vehicle := range train.Vehicles {
+		count += len(s.freeSeatsOf(train, vehicle, from, to))
+	}
+	return count
+}
+
+// freeSeatsOf returns the seats of the vehicle that are not booked anywhere
+// between stop from and stop to.
+func (s *Server) freeSeatsOf(train *Train, vehicle Vehicle, from, to int) []int {
+	var free []int
+	for seat := 1; seat <= vehicle.Seats; seat++ {
+		if !s.booked(train, vehicle.Number, seat, from, to) {
+			free = append(free, seat)
+		}
+	}
+	return free
+}
+
+func (s *Server) booked(train *Train, vehicle, seat, from, to int) bool {
+	for _, booking := range train.Bookings {
+		if booking.Vehicle != vehicle || booking.From >= to || from >= booking.To {
+			continue
+		}
+		if booking.ReleaseAfter > 0 && time.Since(s.started) >= time.Duration(booking.ReleaseAfter) {
+			continue
+		}
+		if len(booking.Seats) == 0 {
+			return true
+		}
+		for _, booked := range booking.Seats {
+			if booked == seat {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func atTime(date time.Time, clock string) time.Time {
+	t, _ := time.Parse("15:04", clock)
+	return time.Date(date.Year(), date.Month(), date.Day(), t.Hour(), t.Minute(), 0, 0, time.Local)
+}
+
+// timetableTime formats a time of day like the timetables of the API do.
+func timetableTime(clock string) string {
+	if clock == "" {
+		return ""
+	}
+	t, _ := time.Parse("15:04", clock)
+	return t.Format("15:04:05.000")
+}
+
+func travelTime(departure, arrival time.Time) string {
+	minutes := int(arrival.Sub(departure).Minutes())
+	return fmt.Sprintf("%02d:%02d h", minutes/60, minutes%60)
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"message": message})
+}
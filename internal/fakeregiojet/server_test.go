@@ -0,0 +1,136 @@
+package fakeregiojet
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bxxf/regiojet-watchdog/internal/models"
+)
+
+const (
+	praha   = "372825000"
+	olomouc = "1313142001"
+	havirov = "508808000"
+)
+
+// get decodes the response of the fake API to a GET request into v.
+func get(t *testing.T, s *Server, url string, v interface{}) int {
+	recorder := httptest.NewRecorder()
+	s.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, url, nil))
+	if v != nil && recorder.Code == http.StatusOK {
+		if err := json.NewDecoder(recorder.Body).Decode(v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return recorder.Code
+}
+
+// freeSeats returns the free seats of the route between the stations.
+func freeSeats(t *testing.T, s *Server, routeID, from, to string) []int {
+	body := `{"sections":[{"sectionId":` + routeID + `,"fromStationId":` + from + `,"toStationId":` + to + `}]}`
+	recorder := httptest.NewRecorder()
+	s.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/routes/"+routeID+"/freeSeats", strings.NewReader(body)))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("got status %d for the free seats", recorder.Code)
+	}
+
+	var response models.FreeSeatsResponse
+	if err := json.NewDecoder(recorder.Body).Decode(&response); err != nil {
+		t.Fatal(err)
+	}
+	var seats []int
+	for _, section := range response {
+		for _, vehicle := range section.Vehicles {
+			for _, seat := range vehicle.FreeSeats {
+				seats = append(seats, vehicle.VehicleNumber*100+seat.Index)
+			}
+		}
+	}
+	return seats
+}
+
+func TestSearchRoutes(t *testing.T) {
+	s := NewServer(DefaultFixture())
+
+	var response models.Response
+	status := get(t, s, "/routes/search/simple?fromLocationId="+olomouc+"&toLocationId="+havirov+"&departureDate=2026-10-17", &response)
+	if status != http.StatusOK {
+		t.Fatalf("got status %d", status)
+	}
+	if len(response.Routes) != 2 {
+		t.Fatalf("found %d routes, want 2", len(response.Routes))
+	}
+	route := response.Routes[0]
+	departure, err := time.Parse(time.RFC3339, route.DepartureTime)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if route.ID != "101020261017" || departure.Format("2006-01-02 15:04") != "2026-10-17 08:22" {
+		t.Errorf("got route %s departing at %s", route.ID, route.DepartureTime)
+	}
+	if route.PriceFrom != 198 {
+		t.Errorf("got price %v, want 198 for two legs", route.PriceFrom)
+	}
+
+	if status := get(t, s, "/routes/search/simple?fromLocationId="+havirov+"&toLocationId="+praha+"&departureDate=2026-10-17", &response); status != http.StatusOK || len(response.Routes) != 0 {
+		t.Errorf("got status %d and %d routes against the direction of the trains", status, len(response.Routes))
+	}
+	if status := get(t, s, "/routes/search/simple?fromLocationId=x", nil); status != http.StatusBadRequest {
+		t.Errorf("got status %d for invalid parameters, want %d", status, http.StatusBadRequest)
+	}
+}
+
+func TestFreeSeats(t *testing.T) {
+	s := NewServer(DefaultFixture())
+
+	if seats := freeSeats(t, s, "101020261017", praha, havirov); len(seats) != 0 {
+		t.Errorf("got free seats %v for the whole route, want none", seats)
+	}
+	if seats := freeSeats(t, s, "101020261017", praha, "372825002"); len(seats) != 1 || seats[0] != 105 {
+		t.Errorf("got free seats %v for the first leg, want seat 5 of vehicle 1", seats)
+	}
+
+	var details models.RouteDetailsResponse
+	if status := get(t, s, "/routes/101020261017/simple?fromStationId="+praha+"&toStationId=372825002", &details); status != http.StatusOK {
+		t.Fatalf("got status %d for the route details", status)
+	}
+	if details.FreeSeatsCount != 1 || details.DepartureCityName != "Praha" {
+		t.Errorf("got %d free seats from %s", details.FreeSeatsCount, details.DepartureCityName)
+	}
+}
+
+func TestBookingsAreReleased(t *testing.T) {
+	s := NewServer(DefaultFixture())
+	s.started = time.Now().Add(-3 * time.Minute)
+
+	if seats := freeSeats(t, s, "101020261017", praha, havirov); len(seats) != 1 || seats[0] != 103 {
+		t.Errorf("got free seats %v after the release, want seat 3 of vehicle 1", seats)
+	}
+}
+
+func TestUnknownRoutes(t *testing.T) {
+	s := NewServer(DefaultFixture())
+
+	for _, url := range []string{"/routes/999920261017/simple", "/routes/abc/simple", "/consts/timetables/999920261017"} {
+		if status := get(t, s, url, nil); status != http.StatusNotFound {
+			t.Errorf("got status %d for %s, want %d", status, url, http.StatusNotFound)
+		}
+	}
+}
+
+func TestLoadFixtureRejectsInvalidFixtures(t *testing.T) {
+	fixtures := map[string]string{
+		"unknown station": `{"trains":[{"id":1,"stops":[{"stationId":1,"departure":"06:00"},{"stationId":2,"arrival":"07:00"}]}]}`,
+		"invalid time":    `{"stations":[{"id":1},{"id":2}],"trains":[{"id":1,"stops":[{"stationId":1,"departure":"6"},{"stationId":2,"arrival":"07:00"}]}]}`,
+		"invalid booking": `{"stations":[{"id":1},{"id":2}],"trains":[{"id":1,"stops":[{"stationId":1,"departure":"06:00"},{"stationId":2,"arrival":"07:00"}],"bookings":[{"from":1}]}]}`,
+	}
+	for name, data := range fixtures {
+		if _, err := LoadFixture([]byte(data)); err == nil {
+			t.Errorf("loaded the fixture with an %s", name)
+		}
+	}
+}
@@ -1,5 +1,7 @@
 package models
 
+import "time"
+
 type TrainTicket struct {
 	ID             string   `json:"id"`
 	DepartureTime  string   `json:"departureTime"`
@@ -34,10 +36,6 @@ type Section struct {
 
 type FreeSeatsResponse []Section
 
-type FreeSeatsError struct {
-	Message string `json:"message"`
-}
-
 type RouteDetails struct {
 	PriceFrom         float64 `json:"priceFrom"`
 	PriceTo           float64 `json:"priceTo"`
@@ -86,3 +84,142 @@ type Stop struct {
 	Symbols   []string `json:"symbols"`
 	Platform  string   `json:"platform"`
 }
+
+// WatchdogVersion is the schema version of the stored Watchdog record.
+// Version 2 replaced the single Discord webhookURL with Targets.
+const WatchdogVersion = 2
+
+// NotificationTarget is a single channel a watchdog notifies, e.g. a Discord
+// webhook. Settings are specific to the Type.
+type NotificationTarget struct {
+	Type     string            `json:"type"`
+	Settings map[string]string `json:"settings"`
+}
+
+type Watchdog struct {
+	Version              int                   `json:"version"`
+	ID                   string                `json:"id"`
+	StationFromID        string                `json:"stationFromID"`
+	StationToID          string                `json:"stationToID"`
+	RouteID              string                `json:"routeID"`
+	Targets              []NotificationTarget  `json:"targets"`
+	SeatClass            string                `json:"seatClass,omitempty"`
+	Passengers           int                   `json:"passengers,omitempty"`
+	Owner                string                `json:"owner,omitempty"`
+	CheckIntervalSeconds int                   `json:"checkIntervalSeconds,omitempty"`
+	Ranking              *Ranking              `json:"ranking,omitempty"`
+	DepartureTime        time.Time             `json:"departureTime"`
+	CreatedAt            time.Time             `json:"createdAt"`
+	LastNotifiedAt       *time.Time            `json:"lastNotifiedAt,omitempty"`
+	LastSnapshot         *AvailabilitySnapshot `json:"lastSnapshot,omitempty"`
+	ExpiresAt            time.Time             `json:"expiresAt"`
+}
+
+// Objectives alternative routes can be ranked by.
+const (
+	// ObjectiveChanges ranks by the fewest seat changes, then by price.
+	ObjectiveChanges = "changes"
+	// ObjectivePrice ranks by the lowest total price, then by seat changes.
+	ObjectivePrice = "price"
+	// ObjectiveSeats ranks by the most free seats on the fullest segment, then
+	// by seat changes and price.
+	ObjectiveSeats = "seats"
+	// ObjectiveWeighted ranks by the Weights of seat changes, price and free
+	// seats.
+	ObjectiveWeighted = "weighted"
+)
+
+// MaxAlternatives is the most alternative routes a search returns, which keeps
+// them within the limits of a Discord embed.
+const MaxAlternatives = 10
+
+// Ranking selects the alternative routes found for a watchdog. Zero fields
+// take the configured defaults.
+type Ranking struct {
+	Objective string          `json:"objective,omitempty"`
+	Limit     int             `json:"limit,omitempty"`
+	Weights   *RankingWeights `json:"weights,omitempty"`
+}
+
+// RankingWeights score a route of the weighted objective as
+// Changes*seat changes + Price*total price - Seats*free seats of its fullest
+// segment, the lowest score first.
+type RankingWeights struct {
+	Changes float64 `json:"changes"`
+	Price   float64 `json:"price"`
+	Seats   float64 `json:"seats"`
+}
+
+// Segment is a part of a journey with free seats, on a seat that can be kept
+// from its first to its last station.
+type Segment struct {
+	RouteID         string    `json:"routeID"`
+	FromStationID   string    `json:"fromStationID"`
+	FromStationName string    `json:"fromStationName"`
+	ToStationID     string    `json:"toStationID"`
+	ToStationName   string    `json:"toStationName"`
+	DepartureTime   time.Time `json:"departureTime"`
+	ArrivalTime     time.Time `json:"arrivalTime"`
+	Price           float64   `json:"price"`
+	FreeSeats       int       `json:"freeSeats"`
+	// Seat is a seat free for the whole segment, if one is known, and
+	// SeatClass its class.
+	Seat      *Seat  `json:"seat,omitempty"`
+	SeatClass string `json:"seatClass,omitempty"`
+}
+
+// Seat is a physical seat of a train.
+type Seat struct {
+	VehicleNumber int `json:"vehicleNumber"`
+	Index         int `json:"index"`
+}
+
+// SegmentPath is an alternative route, a sequence of segments leading from
+// the first station of the watched connection to the last one. Its segments
+// are on the watched train, or on other trains of the same day for a part of
+// the way.
+type SegmentPath struct {
+	Segments   []Segment `json:"segments"`
+	TotalPrice float64   `json:"totalPrice"`
+	// SeatChanges counts the stations where the seat changes, one less than
+	// the segments, as segments kept on one seat are merged.
+	SeatChanges int `json:"seatChanges"`
+	// Transfers counts the stations where the train changes.
+	Transfers int `json:"transfers"`
+}
+
+// AvailabilitySnapshot is what a check of a watchdog observed, used to only
+// notify when the availability changes.
+type AvailabilitySnapshot struct {
+	FreeSeatsCount int `json:"freeSeatsCount"`
+	// FreeSeats counts the free seats per "<vehicle number>/<seat class>".
+	FreeSeats map[string]int `json:"freeSeats,omitempty"`
+	// Alternatives is a hash of the stations and trains of all alternative
+	// routes.
+	Alternatives string    `json:"alternatives,omitempty"`
+	ObservedAt   time.Time `json:"observedAt"`
+}
+
+const (
+	NotificationFreeSeats    = "free_seats"
+	NotificationSoldOut      = "sold_out"
+	NotificationAlternatives = "alternatives"
+)
+
+// Notification is a queued delivery of one watchdog event to one of its
+// targets. It keeps everything the notifier needs, so it can be retried after
+// the watchdog has changed or expired.
+type Notification struct {
+	ID           string             `json:"id"`
+	Kind         string             `json:"kind"`
+	Watchdog     Watchdog           `json:"watchdog"`
+	Target       NotificationTarget `json:"target"`
+	FreeSeats    FreeSeatsResponse  `json:"freeSeats,omitempty"`
+	RouteDetails *RouteDetails      `json:"routeDetails,omitempty"`
+	Alternatives []SegmentPath      `json:"alternatives,omitempty"`
+
+	Attempts      int       `json:"attempts"`
+	LastError     string    `json:"lastError,omitempty"`
+	CreatedAt     time.Time `json:"createdAt"`
+	NextAttemptAt time.Time `json:"nextAttemptAt"`
+}
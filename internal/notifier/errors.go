@@ -0,0 +1,47 @@
+package notifier
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/bxxf/regiojet-watchdog/internal/retryafter"
+)
+
+// DeliveryError is returned by notifiers when a channel rejects a
+// notification, so the sender knows whether and when to retry it.
+type DeliveryError struct {
+	StatusCode int
+	// RetryAfter is how long the channel asked to wait before retrying, zero
+	// if it did not say.
+	RetryAfter time.Duration
+	// Rejected is set by channels without HTTP status codes when the
+	// notification was refused for good.
+	Rejected bool
+	Message  string
+}
+
+// NewStatusError returns the error of an unexpected HTTP response of the
+// channel, taking the delay from the Retry-After header.
+func NewStatusError(channel string, resp *http.Response) *DeliveryError {
+	return &DeliveryError{
+		StatusCode: resp.StatusCode,
+		RetryAfter: retryafter.Parse(resp.Header, time.Now()),
+		Message:    fmt.Sprintf("failed to send %s notification, status code: %d", channel, resp.StatusCode),
+	}
+}
+
+func (e *DeliveryError) Error() string {
+	return e.Message
+}
+
+func (e *DeliveryError) RateLimited() bool {
+	return e.StatusCode == http.StatusTooManyRequests
+}
+
+// Permanent reports whether retrying is pointless, e.g. because the webhook
+// was deleted or the request is invalid.
+func (e *DeliveryError) Permanent() bool {
+	return e.Rejected || e.StatusCode >= 400 && e.StatusCode < 500 &&
+		e.StatusCode != http.StatusRequestTimeout && e.StatusCode != http.StatusTooManyRequests
+}
@@ -0,0 +1,92 @@
+package notifier
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/bxxf/regiojet-watchdog/internal/models"
+)
+
+// VehicleSeats is the number of free seats of one seat class in one vehicle.
+type VehicleSeats struct {
+	VehicleNumber int    `json:"vehicleNumber"`
+	SeatClass     string `json:"seatClass"`
+	FreeSeats     int    `json:"freeSeats"`
+}
+
+// CountFreeSeats sums the free seats per vehicle and seat class, ordered by
+// vehicle number and seat class.
+func CountFreeSeats(freeSeats models.FreeSeatsResponse) []VehicleSeats {
+	type key struct {
+		vehicleNumber int
+		seatClass     string
+	}
+
+	counts := make(map[key]int)
+	for _, section := range freeSeats {
+		for _, vehicle := range section.Vehicles {
+			for _, seat := range vehicle.FreeSeats {
+				counts[key{vehicle.VehicleNumber, seat.SeatClass}]++
+			}
+		}
+	}
+
+	vehicleSeats := make([]VehicleSeats, 0, len(counts))
+	for k, count := range counts {
+		vehicleSeats = append(vehicleSeats, VehicleSeats{
+			VehicleNumber: k.vehicleNumber,
+			SeatClass:     k.seatClass,
+			FreeSeats:     count,
+		})
+	}
+
+	sort.Slice(vehicleSeats, func(i, j int) bool {
+		if vehicleSeats[i].VehicleNumber != vehicleSeats[j].VehicleNumber {
+			return vehicleSeats[i].VehicleNumber < vehicleSeats[j].VehicleNumber
+		}
+		return vehicleSeats[i].SeatClass < vehicleSeats[j].SeatClass
+	})
+	return vehicleSeats
+}
+
+// Clock formats an RFC 3339 time of the RegioJet API as "15:04".
+func Clock(value string) string {
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return value
+	}
+	return t.Format("15:04")
+}
+
+// Date formats an RFC 3339 time of the RegioJet API as "02.01.2006".
+func Date(value string) string {
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return value
+	}
+	return t.Format("02.01.2006")
+}
+
+// Journey returns the first and the last station and the departure date of
+// the alternative routes, which all cover the same journey.
+func Journey(paths []models.SegmentPath) (from, to, date string) {
+	if len(paths) == 0 || len(paths[0].Segments) == 0 {
+		return "", "", ""
+	}
+	segments := paths[0].Segments
+	first, last := segments[0], segments[len(segments)-1]
+	return first.FromStationName, last.ToStationName, first.DepartureTime.Format("02.01.2006")
+}
+
+// SeatName describes the seat of a segment, e.g. "12 in vehicle 3 (C0)".
+func SeatName(segment models.Segment) string {
+	if segment.Seat == nil {
+		return "unknown"
+	}
+	name := fmt.Sprintf("%d in vehicle %d", segment.Seat.Index, segment.Seat.VehicleNumber)
+	if segment.SeatClass != "" {
+		name += " (" + segment.SeatClass + ")"
+	}
+	return name
+}
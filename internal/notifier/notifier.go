@@ -0,0 +1,238 @@
+package notifier
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/bxxf/regiojet-watchdog/internal/database"
+	"github.com/bxxf/regiojet-watchdog/internal/models"
+	"github.com/google/uuid"
+	"go.uber.org/fx"
+	"go.uber.org/zap"
+)
+
+// Notifier delivers watchdog notifications to one type of channel. Each
+// watchdog target names the Type of the notifier that handles it.
+type Notifier interface {
+	Type() string
+	Validate(target models.NotificationTarget) error
+	NotifyFreeSeats(ctx context.Context, watchdog models.Watchdog, target models.NotificationTarget, freeSeats models.FreeSeatsResponse, routeDetails models.RouteDetails) error
+	// NotifySoldOut tells that the last free seats of the route are gone.
+	NotifySoldOut(ctx context.Context, watchdog models.Watchdog, target models.NotificationTarget, routeDetails models.RouteDetails) error
+	NotifyAlternatives(ctx context.Context, watchdog models.Watchdog, target models.NotificationTarget, paths []models.SegmentPath) error
+}
+
+// SecretNotifier is a Notifier whose targets have settings that must not be
+// shown back, such as webhook URLs anyone can post to.
+type SecretNotifier interface {
+	Notifier
+	SecretSettings() []string
+}
+
+// hintLength is how many last characters of a secret setting are shown.
+const hintLength = 4
+
+type NotificationParams struct {
+	fx.In
+
+	Logger    *zap.Logger
+	Store     database.NotificationStore
+	Notifiers []Notifier `group:"notifiers"`
+}
+
+// NotificationService queues a notification for each of the watchdog's
+// targets, which the Sender then delivers through the notifier of the
+// target's type.
+type NotificationService struct {
+	logger    *zap.Logger
+	store     database.NotificationStore
+	notifiers map[string]Notifier
+	// queued wakes the Sender up when a notification is queued.
+	queued chan struct{}
+}
+
+func NewNotificationService(params NotificationParams) *NotificationService {
+	notifiers := make(map[string]Notifier)
+	for _, n := range params.Notifiers {
+		notifiers[n.Type()] = n
+	}
+
+	return &NotificationService{
+		logger:    params.Logger,
+		store:     params.Store,
+		notifiers: notifiers,
+		queued:    make(chan struct{}, 1),
+	}
+}
+
+// AsNotifier annotates a notifier constructor so that fx adds it to the
+// notifiers group consumed by NewNotificationService.
+func AsNotifier(constructor interface{}) interface{} {
+	return fx.Annotate(
+		constructor,
+		fx.As(new(Notifier)),
+		fx.ResultTags(`group:"notifiers"`),
+	)
+}
+
+func (s *NotificationService) Validate(targets []models.NotificationTarget) error {
+	if len(targets) == 0 {
+		return errors.New("at least one notification target is required")
+	}
+
+	for i, target := range targets {
+		n, ok := s.notifiers[target.Type]
+		if !ok {
+			return fmt.Errorf("target %d: unknown type %q", i, target.Type)
+		}
+		if err := n.Validate(target); err != nil {
+			return fmt.Errorf("target %d: %v", i, err)
+		}
+	}
+	return nil
+}
+
+// Redact returns the targets with their secret settings masked, showing only
+// the last characters of long ones as a hint.
+func (s *NotificationService) Redact(targets []models.NotificationTarget) []models.NotificationTarget {
+	if targets == nil {
+		return nil
+	}
+
+	redacted := make([]models.NotificationTarget, len(targets))
+	for i, target := range targets {
+		redacted[i] = target
+		n, ok := s.notifiers[target.Type].(SecretNotifier)
+		if !ok {
+			continue
+		}
+
+		settings := make(map[string]string, len(target.Settings))
+		for key, value := range target.Settings {
+			settings[key] = value
+		}
+		for _, key := range n.SecretSettings() {
+			if value, ok := settings[key]; ok {
+				settings[key] = mask(value)
+			}
+		}
+		redacted[i].Settings = settings
+	}
+	return redacted
+}
+
+func mask(secret string) string {
+	if len(secret) < 4*hintLength {
+		return "****"
+	}
+	return "****" + secret[len(secret)-hintLength:]
+}
+
+func (s *NotificationService) NotifyFreeSeats(ctx context.Context, watchdog models.Watchdog, freeSeats models.FreeSeatsResponse, routeDetails models.RouteDetails) error {
+	return s.enqueue(ctx, watchdog, models.Notification{
+		Kind:         models.NotificationFreeSeats,
+		FreeSeats:    freeSeats,
+		RouteDetails: &routeDetails,
+	})
+}
+
+func (s *NotificationService) NotifySoldOut(ctx context.Context, watchdog models.Watchdog, routeDetails models.RouteDetails) error {
+	return s.enqueue(ctx, watchdog, models.Notification{
+		Kind:         models.NotificationSoldOut,
+		RouteDetails: &routeDetails,
+	})
+}
+
+func (s *NotificationService) NotifyAlternatives(ctx context.Context, watchdog models.Watchdog, paths []models.SegmentPath) error {
+	return s.enqueue(ctx, watchdog, models.Notification{
+		Kind:         models.NotificationAlternatives,
+		Alternatives: paths,
+	})
+}
+
+// Requeue queues a dead-lettered notification again as if it was new, with a
+// fresh number of attempts.
+func (s *NotificationService) Requeue(ctx context.Context, notification models.Notification) error {
+	notification.Attempts = 0
+	notification.CreatedAt = time.Now()
+	notification.NextAttemptAt = notification.CreatedAt
+	if err := s.store.EnqueueNotification(ctx, notification); err != nil {
+		return err
+	}
+	s.wake()
+	return s.store.DeleteDeadLetter(ctx, notification.ID)
+}
+
+// enqueue queues a copy of notification for every target of the watchdog, so
+// each target is retried on its own and one failing channel does not delay
+// or repeat the notifications of the others.
+func (s *NotificationService) enqueue(ctx context.Context, watchdog models.Watchdog, notification models.Notification) error {
+	now := time.Now()
+	// the snapshot is not needed to deliver the notification
+	watchdog.LastSnapshot = nil
+
+	var errs []error
+	for _, target := range watchdog.Targets {
+		if _, ok := s.notifiers[target.Type]; !ok {
+			// queueing it again would not help, but would repeat the
+			// notification to the other targets
+			s.logger.Error("Skipping notification of unknown target type",
+				zap.String("watchdog", watchdog.ID),
+				zap.String("type", target.Type),
+			)
+			continue
+		}
+
+		n := notification
+		n.ID = uuid.New().String()
+		n.Watchdog = watchdog
+		n.Target = target
+		n.CreatedAt = now
+		n.NextAttemptAt = now
+		if err := s.store.EnqueueNotification(ctx, n); err != nil {
+			s.logger.Error("Failed to queue notification",
+				zap.String("watchdog", watchdog.ID),
+				zap.String("type", target.Type),
+				zap.Error(err),
+			)
+			errs = append(errs, err)
+		}
+	}
+
+	s.wake()
+	return errors.Join(errs...)
+}
+
+func (s *NotificationService) wake() {
+	select {
+	case s.queued <- struct{}{}:
+	default:
+	}
+}
+
+// deliver sends the notification through the notifier of its target.
+func (s *NotificationService) deliver(ctx context.Context, notification models.Notification) error {
+	n, ok := s.notifiers[notification.Target.Type]
+	if !ok {
+		return fmt.Errorf("unknown notification target type %q", notification.Target.Type)
+	}
+
+	switch notification.Kind {
+	case models.NotificationFreeSeats:
+		if notification.RouteDetails == nil {
+			return errors.New("free seats notification without route details")
+		}
+		return n.NotifyFreeSeats(ctx, notification.Watchdog, notification.Target, notification.FreeSeats, *notification.RouteDetails)
+	case models.NotificationSoldOut:
+		if notification.RouteDetails == nil {
+			return errors.New("sold out notification without route details")
+		}
+		return n.NotifySoldOut(ctx, notification.Watchdog, notification.Target, *notification.RouteDetails)
+	case models.NotificationAlternatives:
+		return n.NotifyAlternatives(ctx, notification.Watchdog, notification.Target, notification.Alternatives)
+	default:
+		return fmt.Errorf("unknown notification kind %q", notification.Kind)
+	}
+}
@@ -0,0 +1,50 @@
+package notifier_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/bxxf/regiojet-watchdog/internal/database"
+	"github.com/bxxf/regiojet-watchdog/internal/discord"
+	"github.com/bxxf/regiojet-watchdog/internal/models"
+	"github.com/bxxf/regiojet-watchdog/internal/notifier"
+	"github.com/bxxf/regiojet-watchdog/internal/slack"
+	"github.com/bxxf/regiojet-watchdog/internal/telegram"
+	"github.com/bxxf/regiojet-watchdog/internal/webhook"
+	"go.uber.org/zap"
+)
+
+func TestRedact(t *testing.T) {
+	logger := zap.NewNop()
+	s := notifier.NewNotificationService(notifier.NotificationParams{
+		Logger: logger,
+		Store:  database.NewMemoryStore(),
+		Notifiers: []notifier.Notifier{
+			discord.NewDiscordService(logger),
+			slack.NewSlackService(logger),
+			webhook.NewWebhookService(logger),
+		},
+	})
+
+	targets := []models.NotificationTarget{
+		discord.Target("https://discord.com/api/webhooks/1/token-abcd"),
+		slack.Target("https://hooks.slack.com/services/T0/B0/short"),
+		{Type: "webhook", Settings: map[string]string{"url": "https://example.com/hook", "secret": "at-least-16-characters"}},
+		{Type: "webhook", Settings: map[string]string{"url": "https://example.com/hook", "secret": "short"}},
+		telegram.Target("42"),
+	}
+	want := []models.NotificationTarget{
+		discord.Target("****abcd"),
+		slack.Target("****hort"),
+		{Type: "webhook", Settings: map[string]string{"url": "https://example.com/hook", "secret": "****ters"}},
+		{Type: "webhook", Settings: map[string]string{"url": "https://example.com/hook", "secret": "****"}},
+		telegram.Target("42"),
+	}
+
+	if got := s.Redact(targets); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if secret := targets[2].Settings["secret"]; secret != "at-least-16-characters" {
+		t.Errorf("the target itself was redacted to %q", secret)
+	}
+}
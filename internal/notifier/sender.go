@@ -0,0 +1,228 @@
+package notifier
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math/rand"
+	"time"
+
+	"github.com/bxxf/regiojet-watchdog/internal/config"
+	"github.com/bxxf/regiojet-watchdog/internal/database"
+	"github.com/bxxf/regiojet-watchdog/internal/models"
+	"go.uber.org/fx"
+	"go.uber.org/zap"
+)
+
+const (
+	pollInterval = 5 * time.Second
+	batchSize    = 20
+	sendTimeout  = 30 * time.Second
+	// claimTimeout is how long a claimed notification is hidden from other
+	// claims. It is well above sendTimeout, so a notification is only sent
+	// twice when the sender dies while sending it.
+	claimTimeout = 5 * time.Minute
+	maxBackoff   = 30 * time.Minute
+	// maxRateLimitedAge is how long after it was queued a rate limited
+	// notification is still retried, so a channel that keeps rate limiting
+	// does not keep it in the queue forever.
+	maxRateLimitedAge = 24 * time.Hour
+)
+
+// Sender delivers the queued notifications. Failed deliveries are retried
+// with exponential backoff, or after the delay a rate limited channel asked
+// for, and moved to the dead-letter list after the configured number of
+// attempts, or when still rate limited maxRateLimitedAge after being queued.
+type Sender struct {
+	logger      *zap.Logger
+	store       database.NotificationStore
+	service     *NotificationService
+	maxAttempts int
+	backoff     time.Duration
+
+	// blockedUntil holds the targets that are rate limited, so the rest of
+	// their notifications wait without using up attempts.
+	blockedUntil map[string]time.Time
+	ctx          context.Context
+	cancel       context.CancelFunc
+	stop         chan struct{}
+	done         chan struct{}
+}
+
+func NewSender(config config.Config, logger *zap.Logger, store database.NotificationStore, service *NotificationService) *Sender {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Sender{
+		logger:       logger,
+		store:        store,
+		service:      service,
+		maxAttempts:  config.NotifyMaxAttempts,
+		backoff:      config.NotifyRetryBackoff,
+		blockedUntil: make(map[string]time.Time),
+		ctx:          ctx,
+		cancel:       cancel,
+		stop:         make(chan struct{}),
+		done:         make(chan struct{}),
+	}
+}
+
+func (s *Sender) run() {
+	defer close(s.done)
+
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	for {
+		s.sendDue()
+
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+		case <-s.service.queued:
+		}
+	}
+}
+
+// sendDue sends claimed batches of due notifications until none are left.
+func (s *Sender) sendDue() {
+	for {
+		select {
+		case <-s.stop:
+			return
+		default:
+		}
+
+		notifications, err := s.store.ClaimNotifications(context.Background(), time.Now(), batchSize, claimTimeout)
+		if err != nil {
+			s.logger.Error("Failed to fetch queued notifications", zap.Error(err))
+			return
+		}
+		for _, notification := range notifications {
+			s.send(notification)
+		}
+		if len(notifications) < batchSize {
+			return
+		}
+	}
+}
+
+func (s *Sender) send(notification models.Notification) {
+	now := time.Now()
+	target := targetKey(notification.Target)
+	if until, ok := s.blockedUntil[target]; ok {
+		if now.Before(until) {
+			notification.NextAttemptAt = until
+			s.save(notification)
+			return
+		}
+		delete(s.blockedUntil, target)
+	}
+
+	ctx, cancel := context.WithTimeout(s.ctx, sendTimeout)
+	err := s.service.deliver(ctx, notification)
+	cancel()
+
+	if err != nil && s.ctx.Err() != nil {
+		// cut short by stopping, which is not a failed attempt
+		notification.NextAttemptAt = now
+		s.save(notification)
+		return
+	}
+
+	if err == nil {
+		if err := s.store.DeleteNotification(context.Background(), notification.ID); err != nil {
+			s.logger.Error("Failed to remove delivered notification", zap.String("notification", notification.ID), zap.Error(err))
+		}
+		return
+	}
+
+	notification.LastError = err.Error()
+	logger := s.logger.With(
+		zap.String("notification", notification.ID),
+		zap.String("watchdog", notification.Watchdog.ID),
+		zap.String("type", notification.Target.Type),
+		zap.Error(err),
+	)
+
+	var deliveryErr *DeliveryError
+	errors.As(err, &deliveryErr)
+
+	if deliveryErr != nil && deliveryErr.RateLimited() && now.Sub(notification.CreatedAt) < maxRateLimitedAge {
+		// being rate limited is not a failure of the notification, so it is
+		// retried as soon as the channel allows without using up an attempt
+		delay := deliveryErr.RetryAfter
+		if delay <= 0 {
+			delay = s.backoff
+		}
+		notification.NextAttemptAt = now.Add(delay)
+		s.blockedUntil[target] = notification.NextAttemptAt
+		logger.Warn("Notification was rate limited", zap.Duration("retryAfter", delay))
+		s.save(notification)
+		return
+	}
+
+	notification.Attempts++
+	if notification.Attempts >= s.maxAttempts || (deliveryErr != nil && (deliveryErr.Permanent() || deliveryErr.RateLimited())) {
+		logger.Error("Giving up on notification", zap.Int("attempts", notification.Attempts))
+		if err := s.store.DeadLetterNotification(context.Background(), notification); err != nil {
+			logger.Error("Failed to dead-letter notification", zap.NamedError("storeError", err))
+		}
+		return
+	}
+
+	delay := s.retryDelay(notification.Attempts)
+	if deliveryErr != nil && deliveryErr.RetryAfter > delay {
+		delay = deliveryErr.RetryAfter
+	}
+	notification.NextAttemptAt = now.Add(delay)
+	logger.Warn("Failed to send notification, retrying", zap.Int("attempts", notification.Attempts), zap.Duration("retryIn", delay))
+	s.save(notification)
+}
+
+func (s *Sender) save(notification models.Notification) {
+	if err := s.store.EnqueueNotification(context.Background(), notification); err != nil {
+		s.logger.Error("Failed to reschedule notification", zap.String("notification", notification.ID), zap.Error(err))
+	}
+}
+
+// retryDelay returns the backoff after the given number of failed attempts,
+// with jitter so notifications that failed together are not retried together.
+func (s *Sender) retryDelay(attempts int) time.Duration {
+	delay := s.backoff
+	for i := 1; i < attempts && delay < maxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > maxBackoff {
+		delay = maxBackoff
+	}
+	if delay <= 0 {
+		return 0
+	}
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+// targetKey identifies the channel of a target, e.g. a single Discord
+// webhook.
+func targetKey(target models.NotificationTarget) string {
+	// maps are marshalled with sorted keys
+	settings, _ := json.Marshal(target.Settings)
+	return target.Type + ":" + string(settings)
+}
+
+func RegisterSenderHooks(lc fx.Lifecycle, sender *Sender) {
+	lc.Append(fx.Hook{
+		OnStart: func(context.Context) error {
+			go sender.run()
+			return nil
+		},
+		OnStop: func(ctx context.Context) error {
+			close(sender.stop)
+			sender.cancel()
+			select {
+			case <-sender.done:
+			case <-ctx.Done():
+			}
+			return nil
+		},
+	})
+}
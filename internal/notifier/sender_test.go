@@ -0,0 +1,73 @@
+package notifier
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/bxxf/regiojet-watchdog/internal/config"
+	"github.com/bxxf/regiojet-watchdog/internal/database"
+	"github.com/bxxf/regiojet-watchdog/internal/models"
+	"go.uber.org/zap"
+)
+
+// rateLimitedNotifier is always rate limited.
+type rateLimitedNotifier struct{}
+
+func (rateLimitedNotifier) Type() string { return "limited" }
+
+func (rateLimitedNotifier) Validate(target models.NotificationTarget) error { return nil }
+
+func (rateLimitedNotifier) NotifyFreeSeats(ctx context.Context, watchdog models.Watchdog, target models.NotificationTarget, freeSeats models.FreeSeatsResponse, routeDetails models.RouteDetails) error {
+	return &DeliveryError{StatusCode: http.StatusTooManyRequests, RetryAfter: time.Second, Message: "rate limited"}
+}
+
+func (rateLimitedNotifier) NotifySoldOut(ctx context.Context, watchdog models.Watchdog, target models.NotificationTarget, routeDetails models.RouteDetails) error {
+	return &DeliveryError{StatusCode: http.StatusTooManyRequests, RetryAfter: time.Second, Message: "rate limited"}
+}
+
+func (rateLimitedNotifier) NotifyAlternatives(ctx context.Context, watchdog models.Watchdog, target models.NotificationTarget, paths []models.SegmentPath) error {
+	return &DeliveryError{StatusCode: http.StatusTooManyRequests, RetryAfter: time.Second, Message: "rate limited"}
+}
+
+func TestRateLimitedNotificationsAreGivenUp(t *testing.T) {
+	cases := []struct {
+		age        time.Duration
+		deadLetter bool
+	}{
+		{age: time.Hour},
+		{age: maxRateLimitedAge, deadLetter: true},
+	}
+	for _, c := range cases {
+		store := database.NewMemoryStore()
+		logger := zap.NewNop()
+		service := NewNotificationService(NotificationParams{Logger: logger, Store: store, Notifiers: []Notifier{rateLimitedNotifier{}}})
+		sender := NewSender(config.Config{NotifyMaxAttempts: 5, NotifyRetryBackoff: time.Second}, logger, store, service)
+
+		now := time.Now()
+		sender.send(models.Notification{
+			ID:            "a",
+			Kind:          models.NotificationAlternatives,
+			Target:        models.NotificationTarget{Type: "limited"},
+			CreatedAt:     now.Add(-c.age),
+			NextAttemptAt: now,
+		})
+
+		ctx := context.Background()
+		deadLetters, err := store.ListDeadLetters(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		queued, err := store.ClaimNotifications(ctx, now.Add(time.Hour), 10, time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c.deadLetter && (len(deadLetters) != 1 || len(queued) != 0) {
+			t.Errorf("queued %v ago: got %d dead letters and %d queued, want it dead-lettered", c.age, len(deadLetters), len(queued))
+		}
+		if !c.deadLetter && (len(deadLetters) != 0 || len(queued) != 1 || queued[0].Attempts != 0) {
+			t.Errorf("queued %v ago: got %d dead letters and %v queued, want it queued again without using up an attempt", c.age, len(deadLetters), queued)
+		}
+	}
+}
@@ -0,0 +1,195 @@
+// Package recorder records requests to the RegioJet API and their responses
+// to disk, and replays them without network access, e.g. to reproduce a bug
+// with the responses that caused it.
+package recorder
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// ModeRecord and ModeReplay are the values of UPSTREAM_RECORDING.
+const (
+	ModeRecord = "record"
+	ModeReplay = "replay"
+)
+
+// Recording is a request and its response as stored on disk.
+type Recording struct {
+	Request  RecordedRequest  `json:"request"`
+	Response RecordedResponse `json:"response"`
+}
+
+type RecordedRequest struct {
+	Method string      `json:"method"`
+	URL    string      `json:"url"`
+	Header http.Header `json:"header,omitempty"`
+	Body   string      `json:"body,omitempty"`
+}
+
+type RecordedResponse struct {
+	StatusCode int         `json:"statusCode"`
+	Header     http.Header `json:"header,omitempty"`
+	Body       string      `json:"body"`
+}
+
+// Transport records or replays the requests made through it. Repeated
+// requests are stored in sequence, and replayed in the same sequence, with
+// the last response repeated once the sequence is used up.
+type Transport struct {
+	mode string
+	dir  string
+	next http.RoundTripper
+
+	mu       sync.Mutex
+	replayed map[string]int
+}
+
+// NewTransport returns a transport recording the requests made through next
+// to dir, or replaying them from dir, depending on mode. Any other mode
+// returns next.
+func NewTransport(mode, dir string, next http.RoundTripper) http.RoundTripper {
+	if mode != ModeRecord && mode != ModeReplay {
+		return next
+	}
+	return &Transport{
+		mode:     mode,
+		dir:      dir,
+		next:     next,
+		replayed: make(map[string]int),
+	}
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		body, err = io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		// a round tripper must not modify the request
+		req = req.Clone(req.Context())
+		req.Body = io.NopCloser(bytes.NewReader(body))
+	}
+
+	key := requestKey(req, body)
+	if t.mode == ModeReplay {
+		return t.replay(req, key)
+	}
+	return t.record(req, key, body)
+}
+
+func (t *Transport) record(req *http.Request, key string, body []byte) (*http.Response, error) {
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	respBody, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(respBody))
+
+	recording := Recording{
+		Request: RecordedRequest{
+			Method: req.Method,
+			URL:    req.URL.RequestURI(),
+			Header: req.Header,
+			Body:   string(body),
+		},
+		Response: RecordedResponse{
+			StatusCode: resp.StatusCode,
+			Header:     resp.Header,
+			Body:       string(respBody),
+		},
+	}
+	data, err := json.MarshalIndent(recording, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if err := os.MkdirAll(t.dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create recordings directory: %v", err)
+	}
+	existing, err := filepath.Glob(filepath.Join(t.dir, key+"-*.json"))
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(t.path(key, len(existing)), data, 0o644); err != nil {
+		return nil, fmt.Errorf("failed to save recording: %v", err)
+	}
+	return resp, nil
+}
+
+func (t *Transport) replay(req *http.Request, key string) (*http.Response, error) {
+	t.mu.Lock()
+	n := t.replayed[key]
+	data, err := os.ReadFile(t.path(key, n))
+	if err == nil {
+		t.replayed[key] = n + 1
+	} else if os.IsNotExist(err) && n > 0 {
+		data, err = os.ReadFile(t.path(key, n-1))
+	}
+	t.mu.Unlock()
+
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("no recording of %s %s", req.Method, req.URL.RequestURI())
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var recording Recording
+	if err := json.Unmarshal(data, &recording); err != nil {
+		return nil, fmt.Errorf("invalid recording of %s %s: %v", req.Method, req.URL.RequestURI(), err)
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", recording.Response.StatusCode, http.StatusText(recording.Response.StatusCode)),
+		StatusCode:    recording.Response.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        recording.Response.Header,
+		Body:          io.NopCloser(strings.NewReader(recording.Response.Body)),
+		ContentLength: int64(len(recording.Response.Body)),
+		Request:       req,
+	}, nil
+}
+
+func (t *Transport) path(key string, n int) string {
+	return filepath.Join(t.dir, fmt.Sprintf("%s-%03d.json", key, n))
+}
+
+// requestKey names the recordings of a request. Requests with the same
+// method, path, query, body and currency share the key, whatever the host.
+func requestKey(req *http.Request, body []byte) string {
+	hash := sha256.New()
+	fmt.Fprintf(hash, "%s %s\n%s\n", req.Method, req.URL.RequestURI(), req.Header.Get("X-Currency"))
+	hash.Write(body)
+
+	name := strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' {
+			return r
+		}
+		return '_'
+	}, strings.Trim(req.URL.Path, "/"))
+	if len(name) > 60 {
+		name = name[len(name)-60:]
+	}
+	return fmt.Sprintf("%s-%s-%x", req.Method, name, hash.Sum(nil)[:6])
+}
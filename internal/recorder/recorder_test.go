@@ -0,0 +1,106 @@
+package recorder
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+// countingServer answers every request with the number of requests it has
+// answered so far.
+func countingServer(t *testing.T) *httptest.Server {
+	count := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		count++
+		io.WriteString(w, strings.Repeat("I", count))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func do(t *testing.T, client *http.Client, method, url, body string) string {
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestReplayAnswersRecordedRequestsInSequence(t *testing.T) {
+	dir := t.TempDir()
+	server := countingServer(t)
+
+	recording := &http.Client{Transport: NewTransport(ModeRecord, dir, http.DefaultTransport)}
+	for _, want := range []string{"I", "II"} {
+		if got := do(t, recording, http.MethodGet, server.URL+"/routes/1/simple", ""); got != want {
+			t.Fatalf("recorded %q, want %q", got, want)
+		}
+	}
+	if got := do(t, recording, http.MethodPost, server.URL+"/routes/1/freeSeats", "{}"); got != "III" {
+		t.Fatalf("recorded %q, want %q", got, "III")
+	}
+	server.Close()
+
+	replaying := &http.Client{Transport: NewTransport(ModeReplay, dir, nil)}
+	for _, want := range []string{"I", "II", "II"} {
+		if got := do(t, replaying, http.MethodGet, server.URL+"/routes/1/simple", ""); got != want {
+			t.Errorf("replayed %q, want %q", got, want)
+		}
+	}
+	if got := do(t, replaying, http.MethodPost, server.URL+"/routes/1/freeSeats", "{}"); got != "III" {
+		t.Errorf("replayed %q, want %q", got, "III")
+	}
+}
+
+func TestReplayFailsOnRequestsThatWereNotRecorded(t *testing.T) {
+	dir := t.TempDir()
+	server := countingServer(t)
+
+	recording := &http.Client{Transport: NewTransport(ModeRecord, dir, http.DefaultTransport)}
+	do(t, recording, http.MethodPost, server.URL+"/routes/1/freeSeats", "{}")
+
+	replaying := &http.Client{Transport: NewTransport(ModeReplay, dir, nil)}
+	for _, body := range []string{"", `{"seatClass":"C0"}`} {
+		req, err := http.NewRequest(http.MethodPost, server.URL+"/routes/1/freeSeats", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := replaying.Do(req); err == nil {
+			t.Errorf("replayed a request with the body %q that was not recorded", body)
+		}
+	}
+}
+
+func TestOtherModesUseTheNextTransport(t *testing.T) {
+	if transport := NewTransport("", t.TempDir(), http.DefaultTransport); transport != http.DefaultTransport {
+		t.Errorf("got transport %T, want the next transport", transport)
+	}
+}
+
+func TestRecordingCreatesTheDirectory(t *testing.T) {
+	dir := t.TempDir() + "/recordings"
+	server := countingServer(t)
+
+	recording := &http.Client{Transport: NewTransport(ModeRecord, dir, http.DefaultTransport)}
+	do(t, recording, http.MethodGet, server.URL+"/consts/locations", "")
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("got %d recordings, want 1", len(entries))
+	}
+}
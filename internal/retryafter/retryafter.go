@@ -0,0 +1,29 @@
+// Package retryafter reads the Retry-After header of the RegioJet API and of
+// the notification channels the same way.
+package retryafter
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Parse returns how long the Retry-After header asks to wait, given either in
+// seconds, which may be fractional, or as an HTTP date. It returns zero if the
+// header is missing, invalid or in the past.
+func Parse(header http.Header, now time.Time) time.Duration {
+	value := header.Get("Retry-After")
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil {
+		if seconds <= 0 {
+			return 0
+		}
+		return time.Duration(seconds * float64(time.Second))
+	}
+	if date, err := http.ParseTime(value); err == nil && date.After(now) {
+		return date.Sub(now)
+	}
+	return 0
+}
@@ -0,0 +1,33 @@
+package retryafter
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	now := time.Date(2026, 10, 17, 6, 0, 0, 0, time.UTC)
+	cases := []struct {
+		value string
+		want  time.Duration
+	}{
+		{"", 0},
+		{"120", 2 * time.Minute},
+		{"1.5", 1500 * time.Millisecond},
+		{"0", 0},
+		{"-3", 0},
+		{"Sat, 17 Oct 2026 06:00:30 GMT", 30 * time.Second},
+		{"Sat, 17 Oct 2026 05:59:00 GMT", 0},
+		{"soon", 0},
+	}
+	for _, c := range cases {
+		header := http.Header{}
+		if c.value != "" {
+			header.Set("Retry-After", c.value)
+		}
+		if got := Parse(header, now); got != c.want {
+			t.Errorf("%q: got %v, want %v", c.value, got, c.want)
+		}
+	}
+}
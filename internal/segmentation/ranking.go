@@ -0,0 +1,335 @@
+package segmentation
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bxxf/regiojet-watchdog/internal/models"
+)
+
+// candidatesPerAlternative is how many paths are searched for every
+// alternative returned. Seats are assigned to the paths found afterwards, and
+// paths that turn out to take the same seats count as one.
+const candidatesPerAlternative = 4
+
+// defaultWeights score a seat change like 50 CZK, and ten more free seats on
+// the fullest segment like one seat change less.
+var defaultWeights = models.RankingWeights{Changes: 1, Price: 0.02, Seats: 0.1}
+
+// ValidateRanking checks a ranking chosen for a watchdog or a search.
+func ValidateRanking(ranking models.Ranking) error {
+	switch ranking.Objective {
+	case "", models.ObjectiveChanges, models.ObjectivePrice, models.ObjectiveSeats, models.ObjectiveWeighted:
+	default:
+		return errors.New("objective must be one of changes, price, seats or weighted")
+	}
+	if ranking.Limit < 0 || ranking.Limit > models.MaxAlternatives {
+		return fmt.Errorf("limit must be between 1 and %d, or 0 for the default", models.MaxAlternatives)
+	}
+	if weights := ranking.Weights; weights != nil {
+		if weights.Changes < 0 || weights.Price < 0 || weights.Seats < 0 {
+			return errors.New("weights must not be negative")
+		}
+		if *weights == (models.RankingWeights{}) {
+			return errors.New("weights must not all be zero")
+		}
+	}
+	return nil
+}
+
+// edge is a segment with free seats from a stop of the journey to a later one,
+// on the route or on another train.
+type edge struct {
+	to      int
+	segment models.Segment
+	onRoute bool
+}
+
+// path is a sequence of segments through the stops of the journey.
+type path struct {
+	stops    []int
+	segments []models.Segment
+	price    float64
+	// minSeats is the number of free seats of the fullest segment.
+	minSeats int
+}
+
+type ranker struct {
+	objective string
+	limit     int
+	weights   models.RankingWeights
+}
+
+// ranker returns the ranker of the ranking, with the configured defaults in
+// place of its zero fields.
+func (s *SegmentationService) ranker(ranking *models.Ranking) ranker {
+	r := ranker{objective: s.objective, limit: s.limit, weights: defaultWeights}
+	if ranking == nil {
+		return r
+	}
+	if ranking.Objective != "" {
+		r.objective = ranking.Objective
+	}
+	if ranking.Limit > 0 {
+		r.limit = ranking.Limit
+	}
+	if ranking.Weights != nil {
+		r.weights = *ranking.Weights
+	}
+	return r
+}
+
+// rank returns the best paths through the graph, candidatesPerAlternative for
+// every alternative, changing trains only with at least minTransfer to spare.
+// Ranking by the fullest segment does not add up along a path, so for those
+// objectives it searches once for every number of free seats, using only the
+// segments with at least that many. A path whose fullest segment has n free
+// seats is found by the search for n, unless as many paths as searched rank at
+// least as well there, and those rank at least as well overall too.
+func (r ranker) rank(graph [][]edge, minTransfer time.Duration) []path {
+	limit := r.limit * candidatesPerAlternative
+	thresholds := []int{0}
+	if r.objective == models.ObjectiveSeats || r.objective == models.ObjectiveWeighted && r.weights.Seats != 0 {
+		thresholds = seatCounts(graph)
+	}
+
+	seen := make(map[string]bool)
+	var candidates []path
+	for _, minSeats := range thresholds {
+		for _, p := range bestPaths(graph, limit, minSeats, minTransfer, r.partialBetter) {
+			key := p.key()
+			if !seen[key] {
+				seen[key] = true
+				candidates = append(candidates, p)
+			}
+		}
+	}
+
+	return top(candidates, limit, r.better)
+}
+
+// pick returns the best of the plans, keeping only the best of the plans that
+// take the same seats. Once seats are assigned, a plan changes seats at every
+// station between its segments, so the objectives rank its seat changes.
+func (r ranker) pick(plans []path) []path {
+	plans = top(plans, len(plans), r.better)
+
+	seen := make(map[string]bool)
+	picked := make([]path, 0, r.limit)
+	for _, p := range plans {
+		key := p.seatKey()
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		picked = append(picked, p)
+		if len(picked) == r.limit {
+			break
+		}
+	}
+	return picked
+}
+
+// better orders complete paths by the objective, then by their stops.
+func (r ranker) better(a, b path) bool {
+	switch r.objective {
+	case models.ObjectivePrice:
+		return less(a, b, price, segments)
+	case models.ObjectiveSeats:
+		return less(a, b, fewestSeats, segments, price)
+	case models.ObjectiveWeighted:
+		return less(a, b, r.score, segments, price)
+	default:
+		return less(a, b, segments, price)
+	}
+}
+
+// partialBetter orders the paths from a stop to the last one while they are
+// built. It only uses what the segments of a path add up to, so a best path
+// only continues with best paths.
+func (r ranker) partialBetter(a, b path) bool {
+	switch r.objective {
+	case models.ObjectivePrice:
+		return less(a, b, price, segments)
+	case models.ObjectiveWeighted:
+		return less(a, b, r.partialScore, segments, price)
+	default:
+		return less(a, b, segments, price)
+	}
+}
+
+// score is the weighted score of a complete path, the lowest the best.
+func (r ranker) score(p path) float64 {
+	return r.partialScore(p) - r.weights.Changes - r.weights.Seats*float64(p.minSeats)
+}
+
+// partialScore is the part of the weighted score that adds up along a path.
+func (r ranker) partialScore(p path) float64 {
+	return r.weights.Changes*float64(len(p.segments)) + r.weights.Price*p.price
+}
+
+func segments(p path) float64    { return float64(len(p.segments)) }
+func price(p path) float64       { return p.price }
+func fewestSeats(p path) float64 { return -float64(p.minSeats) }
+
+// key identifies the path by its stations and trains.
+func (p path) key() string {
+	var key strings.Builder
+	for _, segment := range p.segments {
+		fmt.Fprintf(&key, "%s:%s>%s|", segment.RouteID, segment.FromStationID, segment.ToStationID)
+	}
+	return key.String()
+}
+
+// seatKey identifies the plan by the trains and seats it takes, or by the
+// stations of the segments without a seat.
+func (p path) seatKey() string {
+	var key strings.Builder
+	for _, segment := range p.segments {
+		if segment.Seat == nil {
+			fmt.Fprintf(&key, "%s:%s>%s|", segment.RouteID, segment.FromStationID, segment.ToStationID)
+			continue
+		}
+		fmt.Fprintf(&key, "%s:%d/%d|", segment.RouteID, segment.Seat.VehicleNumber, segment.Seat.Index)
+	}
+	return key.String()
+}
+
+// less compares the paths by the keys in turn, the lower the better, then by
+// their stops and then by their departures.
+func less(a, b path, keys ...func(path) float64) bool {
+	for _, key := range keys {
+		if ka, kb := key(a), key(b); ka != kb {
+			return ka < kb
+		}
+	}
+	for i := range a.stops {
+		if i == len(b.stops) {
+			return false
+		}
+		if a.stops[i] != b.stops[i] {
+			return a.stops[i] < b.stops[i]
+		}
+	}
+	if len(a.stops) != len(b.stops) {
+		return len(a.stops) < len(b.stops)
+	}
+	// the same stops on other trains, the earliest first
+	for i := range a.segments {
+		if departure := a.segments[i].DepartureTime; !departure.Equal(b.segments[i].DepartureTime) {
+			return departure.Before(b.segments[i].DepartureTime)
+		}
+	}
+	return false
+}
+
+// seatCounts returns the distinct numbers of free seats of the segments in
+// the graph.
+func seatCounts(graph [][]edge) []int {
+	seen := make(map[int]bool)
+	var counts []int
+	for _, edges := range graph {
+		for _, e := range edges {
+			if !seen[e.segment.FreeSeats] {
+				seen[e.segment.FreeSeats] = true
+				counts = append(counts, e.segment.FreeSeats)
+			}
+		}
+	}
+	sort.Ints(counts)
+	return counts
+}
+
+// bestPaths returns up to limit paths from the first to the last stop of the
+// graph that take the route for at least one segment, best first, only using
+// segments with at least minSeats free seats. It keeps the best paths to the
+// last stop starting with every segment, with and without the route, working
+// backwards, so it takes polynomial time however many paths there are. Which
+// segments may follow only depends on the segment before, and as long as
+// better only depends on what the segments of a path add up to, a best path
+// only continues with best paths, so the result is the same as ranking all
+// paths.
+func bestPaths(graph [][]edge, limit, minSeats int, minTransfer time.Duration, better func(a, b path) bool) []path {
+	n := len(graph)
+	if n < 2 {
+		return nil
+	}
+
+	// best[i][k][1] are the best paths starting with the segment graph[i][k]
+	// that take the route, best[i][k][0] those that do not
+	best := make([][][2][]path, n)
+	for i := n - 2; i >= 0; i-- {
+		best[i] = make([][2][]path, len(graph[i]))
+		for k, e := range graph[i] {
+			if e.segment.FreeSeats < minSeats {
+				continue
+			}
+			onRoute := 0
+			if e.onRoute {
+				onRoute = 1
+			}
+
+			var candidates [2][]path
+			if e.to == n-1 {
+				candidates[onRoute] = []path{{
+					stops:    []int{i, e.to},
+					segments: []models.Segment{e.segment},
+					price:    e.segment.Price,
+					minSeats: e.segment.FreeSeats,
+				}}
+			}
+			for m, next := range graph[e.to] {
+				if !connects(e.segment, next.segment, minTransfer) {
+					continue
+				}
+				for restOnRoute, rests := range best[e.to][m] {
+					for _, rest := range rests {
+						fewest := rest.minSeats
+						if e.segment.FreeSeats < fewest {
+							fewest = e.segment.FreeSeats
+						}
+						candidates[onRoute|restOnRoute] = append(candidates[onRoute|restOnRoute], path{
+							stops:    append([]int{i}, rest.stops...),
+							segments: append([]models.Segment{e.segment}, rest.segments...),
+							price:    e.segment.Price + rest.price,
+							minSeats: fewest,
+						})
+					}
+				}
+			}
+			for r := range candidates {
+				best[i][k][r] = top(candidates[r], limit, better)
+			}
+		}
+	}
+
+	var paths []path
+	for _, candidates := range best[0] {
+		paths = append(paths, candidates[1]...)
+	}
+	return top(paths, limit, better)
+}
+
+// connects reports whether the second segment can follow the first one, on
+// the same train or on a train departing at least minTransfer after the first
+// one arrives.
+func connects(first, second models.Segment, minTransfer time.Duration) bool {
+	if first.RouteID == second.RouteID {
+		return true
+	}
+	return !second.DepartureTime.Before(first.ArrivalTime.Add(minTransfer))
+}
+
+// top returns up to limit of the paths, best first.
+func top(paths []path, limit int, better func(a, b path) bool) []path {
+	sort.Slice(paths, func(a, b int) bool {
+		return better(paths[a], paths[b])
+	})
+	if len(paths) > limit {
+		paths = paths[:limit]
+	}
+	return paths
+}
@@ -0,0 +1,315 @@
+package segmentation
+
+import (
+	"math/rand"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/bxxf/regiojet-watchdog/internal/models"
+)
+
+// watched is the route of the journey in the test graphs.
+const watched = "route"
+
+// testSegment is a segment of a train that leaves the first stop shift after
+// 6:00 and takes an hour from one stop to the next.
+type testSegment struct {
+	route     string
+	shift     time.Duration
+	from, to  int
+	price     float64
+	freeSeats int
+}
+
+// testGraph returns the graph of the segments through the stops.
+func testGraph(stops int, segments ...testSegment) [][]edge {
+	start := time.Date(2026, 10, 17, 6, 0, 0, 0, time.UTC)
+	graph := make([][]edge, stops)
+	for _, s := range segments {
+		graph[s.from] = append(graph[s.from], edge{
+			to:      s.to,
+			onRoute: s.route == watched,
+			segment: models.Segment{
+				RouteID:       s.route,
+				FromStationID: strconv.Itoa(s.from),
+				ToStationID:   strconv.Itoa(s.to),
+				DepartureTime: start.Add(s.shift + time.Duration(s.from)*time.Hour),
+				ArrivalTime:   start.Add(s.shift + time.Duration(s.to)*time.Hour),
+				Price:         s.price,
+				FreeSeats:     s.freeSeats,
+			},
+		})
+	}
+	return graph
+}
+
+// keys returns the keys of the paths.
+func keys(paths []path) []string {
+	keys := []string{}
+	for _, p := range paths {
+		keys = append(keys, p.key())
+	}
+	return keys
+}
+
+func TestBestPaths(t *testing.T) {
+	cases := []struct {
+		name      string
+		stops     int
+		segments  []testSegment
+		limit     int
+		minSeats  int
+		objective string
+		want      []string
+	}{
+		{
+			name:     "direct",
+			stops:    2,
+			segments: []testSegment{{watched, 0, 0, 1, 100, 3}},
+			limit:    5,
+			want:     []string{"route:0>1|"},
+		},
+		{
+			name:  "forced split",
+			stops: 3,
+			segments: []testSegment{
+				{watched, 0, 0, 1, 100, 3},
+				{watched, 0, 1, 2, 100, 3},
+			},
+			limit: 5,
+			want:  []string{"route:0>1|route:1>2|"},
+		},
+		{
+			name:  "no path",
+			stops: 3,
+			segments: []testSegment{
+				{watched, 0, 0, 1, 100, 3},
+				{watched, 0, 0, 2, 100, 0},
+			},
+			limit:    5,
+			minSeats: 1,
+			want:     []string{},
+		},
+		{
+			name:     "only other trains",
+			stops:    2,
+			segments: []testSegment{{"other", time.Hour, 0, 1, 100, 3}},
+			limit:    5,
+			want:     []string{},
+		},
+		{
+			name:  "at least one segment on the route",
+			stops: 3,
+			segments: []testSegment{
+				{"early", -2 * time.Hour, 0, 1, 50, 3},
+				{"early", -2 * time.Hour, 1, 2, 50, 3},
+				{watched, 0, 1, 2, 100, 3},
+				{"late", time.Hour, 0, 2, 100, 3},
+			},
+			limit: 5,
+			want:  []string{"early:0>1|route:1>2|"},
+		},
+		{
+			name:  "transfer too short",
+			stops: 3,
+			segments: []testSegment{
+				{watched, 0, 0, 1, 100, 3},
+				{"close", 2 * time.Minute, 1, 2, 100, 3},
+				{"later", 10 * time.Minute, 1, 2, 100, 3},
+			},
+			limit: 5,
+			want:  []string{"route:0>1|later:1>2|"},
+		},
+		{
+			name:  "limit",
+			stops: 3,
+			segments: []testSegment{
+				{watched, 0, 0, 1, 100, 3},
+				{watched, 0, 1, 2, 100, 3},
+				{watched, 0, 0, 2, 300, 3},
+				{"later", 10 * time.Minute, 1, 2, 50, 3},
+			},
+			limit: 2,
+			want:  []string{"route:0>2|", "route:0>1|later:1>2|"},
+		},
+		{
+			name:  "cheapest first",
+			stops: 3,
+			segments: []testSegment{
+				{watched, 0, 0, 1, 100, 3},
+				{watched, 0, 1, 2, 100, 3},
+				{watched, 0, 0, 2, 300, 3},
+				{"later", 10 * time.Minute, 1, 2, 50, 3},
+			},
+			limit:     5,
+			objective: models.ObjectivePrice,
+			want:      []string{"route:0>1|later:1>2|", "route:0>1|route:1>2|", "route:0>2|"},
+		},
+		{
+			name:  "enough free seats",
+			stops: 3,
+			segments: []testSegment{
+				{watched, 0, 0, 1, 100, 1},
+				{watched, 0, 1, 2, 100, 3},
+				{watched, 0, 0, 2, 300, 2},
+			},
+			limit:    5,
+			minSeats: 2,
+			want:     []string{"route:0>2|"},
+		},
+	}
+	for _, c := range cases {
+		r := ranker{objective: c.objective, limit: c.limit, weights: defaultWeights}
+		got := keys(bestPaths(testGraph(c.stops, c.segments...), c.limit, c.minSeats, 5*time.Minute, r.partialBetter))
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: got %v, want %v", c.name, got, c.want)
+		}
+	}
+}
+
+// allPaths returns every path from the first to the last stop of the graph
+// that takes the route for at least one segment.
+func allPaths(graph [][]edge, minTransfer time.Duration) []path {
+	var paths []path
+	var walk func(p path, onRoute bool)
+	walk = func(p path, onRoute bool) {
+		i := p.stops[len(p.stops)-1]
+		if i == len(graph)-1 {
+			if onRoute {
+				paths = append(paths, p)
+			}
+			return
+		}
+		for _, e := range graph[i] {
+			if len(p.segments) > 0 && !connects(p.segments[len(p.segments)-1], e.segment, minTransfer) {
+				continue
+			}
+			next := path{
+				stops:    append(append([]int{}, p.stops...), e.to),
+				segments: append(append([]models.Segment{}, p.segments...), e.segment),
+				price:    p.price + e.segment.Price,
+				minSeats: e.segment.FreeSeats,
+			}
+			if len(p.segments) > 0 && p.minSeats < next.minSeats {
+				next.minSeats = p.minSeats
+			}
+			walk(next, onRoute || e.onRoute)
+		}
+	}
+	walk(path{stops: []int{0}}, false)
+	return paths
+}
+
+func TestRankMatchesAllPaths(t *testing.T) {
+	rankers := []ranker{
+		{objective: models.ObjectiveChanges, limit: 3},
+		{objective: models.ObjectivePrice, limit: 3},
+		{objective: models.ObjectiveSeats, limit: 3},
+		{objective: models.ObjectiveWeighted, limit: 3, weights: defaultWeights},
+		{objective: models.ObjectiveWeighted, limit: 1, weights: models.RankingWeights{Changes: 2, Price: 0.01, Seats: 1}},
+	}
+	trains := []struct {
+		route string
+		shift time.Duration
+	}{
+		{watched, 0},
+		{"early", -90 * time.Minute},
+		{"late", 45 * time.Minute},
+	}
+
+	random := rand.New(rand.NewSource(1))
+	for n := 0; n < 200; n++ {
+		stops := 2 + random.Intn(5)
+		var segments []testSegment
+		for _, train := range trains {
+			for i := 0; i < stops; i++ {
+				for j := i + 1; j < stops; j++ {
+					if random.Intn(3) > 0 {
+						segments = append(segments, testSegment{train.route, train.shift, i, j, float64(50 + random.Intn(10)*25), 1 + random.Intn(6)})
+					}
+				}
+			}
+		}
+		graph := testGraph(stops, segments...)
+
+		for _, r := range rankers {
+			limit := r.limit * candidatesPerAlternative
+			want := keys(top(allPaths(graph, 5*time.Minute), limit, r.better))
+			got := keys(r.rank(graph, 5*time.Minute))
+			if !reflect.DeepEqual(got, want) {
+				t.Fatalf("graph %d by %s: got %v, want %v", n, r.objective, got, want)
+			}
+		}
+	}
+}
+
+func TestTop(t *testing.T) {
+	paths := []path{
+		{stops: []int{0, 2}, price: 300},
+		{stops: []int{0, 1, 2}, price: 100},
+		{stops: []int{0, 2}, price: 200},
+	}
+	byPrice := func(a, b path) bool { return a.price < b.price }
+
+	got := top(paths, 2, byPrice)
+	if len(got) != 2 || got[0].price != 100 || got[1].price != 200 {
+		t.Errorf("got %v, want the paths of 100 and 200 CZK", got)
+	}
+	if got := top(nil, 2, byPrice); len(got) != 0 {
+		t.Errorf("got %v of no paths", got)
+	}
+}
+
+func TestConnects(t *testing.T) {
+	arrival := time.Date(2026, 10, 17, 8, 0, 0, 0, time.UTC)
+	first := models.Segment{RouteID: watched, ArrivalTime: arrival}
+	cases := []struct {
+		name   string
+		second models.Segment
+		want   bool
+	}{
+		{"same train", models.Segment{RouteID: watched, DepartureTime: arrival}, true},
+		{"enough time", models.Segment{RouteID: "other", DepartureTime: arrival.Add(5 * time.Minute)}, true},
+		{"too little time", models.Segment{RouteID: "other", DepartureTime: arrival.Add(4 * time.Minute)}, false},
+		{"departed", models.Segment{RouteID: "other", DepartureTime: arrival.Add(-time.Hour)}, false},
+	}
+	for _, c := range cases {
+		if got := connects(first, c.second, 5*time.Minute); got != c.want {
+			t.Errorf("%s: got %v, want %v", c.name, got, c.want)
+		}
+	}
+}
+
+func TestSeatCounts(t *testing.T) {
+	graph := testGraph(3,
+		testSegment{watched, 0, 0, 1, 100, 4},
+		testSegment{watched, 0, 1, 2, 100, 1},
+		testSegment{watched, 0, 0, 2, 100, 4},
+		testSegment{"later", time.Hour, 1, 2, 100, 2},
+	)
+	if got, want := seatCounts(graph), []int{1, 2, 4}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestValidateRanking(t *testing.T) {
+	cases := []struct {
+		ranking models.Ranking
+		valid   bool
+	}{
+		{models.Ranking{}, true},
+		{models.Ranking{Objective: models.ObjectivePrice, Limit: models.MaxAlternatives}, true},
+		{models.Ranking{Objective: "fastest"}, false},
+		{models.Ranking{Limit: -1}, false},
+		{models.Ranking{Limit: models.MaxAlternatives + 1}, false},
+		{models.Ranking{Weights: &models.RankingWeights{}}, false},
+		{models.Ranking{Weights: &models.RankingWeights{Price: -1, Seats: 1}}, false},
+	}
+	for _, c := range cases {
+		if err := ValidateRanking(c.ranking); (err == nil) != c.valid {
+			t.Errorf("%+v: got error %v, want valid %v", c.ranking, err, c.valid)
+		}
+	}
+}
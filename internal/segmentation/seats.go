@@ -0,0 +1,173 @@
+package segmentation
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+
+	"github.com/bxxf/regiojet-watchdog/internal/models"
+)
+
+// seat is a physical seat of the train and its class.
+type seat struct {
+	models.Seat
+	class string
+}
+
+// freeSeatsByLeg fetches the free seats between every two neighbouring stops,
+// concurrently. A seat is free from one stop to another when it is free on
+// every leg in between. Legs whose seats fail to be fetched are nil.
+func (s *SegmentationService) freeSeatsByLeg(ctx context.Context, routeID string, stops []models.Stop) ([]map[seat]bool, error) {
+	if _, err := strconv.Atoi(routeID); err != nil {
+		return nil, fmt.Errorf("invalid route ID %s", routeID)
+	}
+
+	legs := make([]map[seat]bool, len(stops)-1)
+	err := s.parallel(ctx, len(legs), func(i int) {
+		legs[i] = s.fetchSeats(ctx, routeID, strconv.Itoa(stops[i].StationID), strconv.Itoa(stops[i+1].StationID))
+	})
+	if err != nil {
+		return nil, err
+	}
+	return legs, nil
+}
+
+// fetchSeats returns the seats of the route free from one station to the
+// other, nil when they fail to be fetched.
+func (s *SegmentationService) fetchSeats(ctx context.Context, routeID, fromStationID, toStationID string) map[seat]bool {
+	routeInt, err := strconv.Atoi(routeID)
+	if err != nil {
+		log.Println("Invalid route ID:", routeID)
+		return nil
+	}
+
+	freeSeats, err := s.trainClient.GetFreeSeats(ctx, routeInt, fromStationID, toStationID)
+	if err != nil {
+		log.Println("Failed to fetch free seats:", err)
+		return nil
+	}
+
+	seats := make(map[seat]bool)
+	for _, section := range freeSeats {
+		for _, vehicle := range section.Vehicles {
+			for _, free := range vehicle.FreeSeats {
+				seats[seat{models.Seat{VehicleNumber: vehicle.VehicleNumber, Index: free.Index}, free.SeatClass}] = true
+			}
+		}
+	}
+	return seats
+}
+
+// segmentSeats returns the seats free for the whole of every segment of the
+// path. Those of the route come from its legs, those of other trains are
+// fetched for the segment.
+func (s *SegmentationService) segmentSeats(ctx context.Context, routeID string, p path, legs []map[seat]bool) []map[seat]bool {
+	seats := make([]map[seat]bool, len(p.segments))
+	for k, segment := range p.segments {
+		if segment.RouteID == routeID {
+			seats[k] = seatsBetween(legs, p.stops[k], p.stops[k+1])
+			continue
+		}
+		seats[k] = s.fetchSeats(ctx, segment.RouteID, segment.FromStationID, segment.ToStationID)
+	}
+	return seats
+}
+
+// seatPlan names a seat for every segment of the path, out of the seats free
+// for it. Each seat is kept for as many segments of its train in a row as it
+// is free, which takes the fewest seat changes the segments allow, and the
+// segments kept on one seat are merged into one. Segments without a seat free
+// all the way keep it unnamed.
+func seatPlan(p path, seats []map[seat]bool) path {
+	plan := path{price: p.price, minSeats: p.minSeats}
+	for k := 0; k < len(p.segments); {
+		var best seat
+		reach := k
+		for _, candidate := range sortedSeats(seats[k]) {
+			r := k + 1
+			for r < len(p.segments) && p.segments[r].RouteID == p.segments[k].RouteID && seats[r][candidate] {
+				r++
+			}
+			if r > reach {
+				best, reach = candidate, r
+			}
+		}
+
+		segment := p.segments[k]
+		plan.stops = append(plan.stops, p.stops[k])
+		if reach == k {
+			plan.segments = append(plan.segments, segment)
+			k++
+			continue
+		}
+
+		last := p.segments[reach-1]
+		segment.ToStationID, segment.ToStationName = last.ToStationID, last.ToStationName
+		segment.ArrivalTime = last.ArrivalTime
+		for _, kept := range p.segments[k+1 : reach] {
+			segment.Price += kept.Price
+			if kept.FreeSeats < segment.FreeSeats {
+				segment.FreeSeats = kept.FreeSeats
+			}
+		}
+		kept := best
+		segment.Seat = &kept.Seat
+		segment.SeatClass = kept.class
+		plan.segments = append(plan.segments, segment)
+		k = reach
+	}
+	plan.stops = append(plan.stops, p.stops[len(p.stops)-1])
+	return plan
+}
+
+// segmentPath returns the plan as an alternative.
+func (p path) segmentPath() models.SegmentPath {
+	alternative := models.SegmentPath{
+		Segments:    p.segments,
+		TotalPrice:  p.price,
+		SeatChanges: len(p.segments) - 1,
+	}
+	for k := 1; k < len(p.segments); k++ {
+		if p.segments[k].RouteID != p.segments[k-1].RouteID {
+			alternative.Transfers++
+		}
+	}
+	return alternative
+}
+
+// seatsBetween returns the seats free on every leg from stop from to stop to.
+func seatsBetween(legs []map[seat]bool, from, to int) map[seat]bool {
+	seats := make(map[seat]bool)
+	for candidate := range legs[from] {
+		if isFree(legs, candidate, from, to) {
+			seats[candidate] = true
+		}
+	}
+	return seats
+}
+
+// sortedSeats orders the seats by vehicle and index.
+func sortedSeats(free map[seat]bool) []seat {
+	seats := make([]seat, 0, len(free))
+	for candidate := range free {
+		seats = append(seats, candidate)
+	}
+	sort.Slice(seats, func(i, j int) bool {
+		if seats[i].VehicleNumber != seats[j].VehicleNumber {
+			return seats[i].VehicleNumber < seats[j].VehicleNumber
+		}
+		return seats[i].Index < seats[j].Index
+	})
+	return seats
+}
+
+func isFree(legs []map[seat]bool, candidate seat, from, to int) bool {
+	for leg := from; leg < to; leg++ {
+		if !legs[leg][candidate] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,69 @@
+package segmentation
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/bxxf/regiojet-watchdog/internal/models"
+)
+
+func TestSeatPlanMergesSegmentsOnOneSeat(t *testing.T) {
+	graph := testGraph(4,
+		testSegment{watched, 0, 0, 1, 100, 3},
+		testSegment{watched, 0, 1, 2, 100, 2},
+		testSegment{watched, 0, 2, 3, 100, 3},
+	)
+	p := path{
+		stops:    []int{0, 1, 2, 3},
+		segments: []models.Segment{graph[0][0].segment, graph[1][0].segment, graph[2][0].segment},
+		price:    300,
+		minSeats: 2,
+	}
+	a := seat{models.Seat{VehicleNumber: 1, Index: 1}, "C"}
+	b := seat{models.Seat{VehicleNumber: 2, Index: 7}, "C"}
+	seats := []map[seat]bool{{a: true, b: true}, {a: true}, {b: true}}
+
+	plan := seatPlan(p, seats)
+	if got, want := plan.key(), "route:0>2|route:2>3|"; got != want {
+		t.Fatalf("got segments %s, want %s", got, want)
+	}
+	first := plan.segments[0]
+	if *first.Seat != a.Seat || first.Price != 200 || first.FreeSeats != 2 || !first.ArrivalTime.Equal(graph[1][0].segment.ArrivalTime) {
+		t.Errorf("got the first segment %+v, want seat %v from 0 to 2 for 200 CZK with 2 free seats", first, a.Seat)
+	}
+	if *plan.segments[1].Seat != b.Seat {
+		t.Errorf("got seat %v for the second segment, want %v", *plan.segments[1].Seat, b.Seat)
+	}
+
+	alternative := plan.segmentPath()
+	if alternative.SeatChanges != 1 || alternative.Transfers != 0 || alternative.TotalPrice != 300 {
+		t.Errorf("got %d seat changes, %d transfers and %v CZK, want 1, 0 and 300", alternative.SeatChanges, alternative.Transfers, alternative.TotalPrice)
+	}
+}
+
+func TestPickDeduplicatesSeats(t *testing.T) {
+	graph := testGraph(3,
+		testSegment{watched, 0, 0, 1, 100, 3},
+		testSegment{watched, 0, 1, 2, 100, 3},
+		testSegment{watched, 0, 0, 2, 200, 3},
+		testSegment{"later", 10 * time.Minute, 1, 2, 150, 3},
+	)
+	a := seat{models.Seat{VehicleNumber: 1, Index: 1}, "C"}
+	b := seat{models.Seat{VehicleNumber: 1, Index: 2}, "C"}
+	direct := path{stops: []int{0, 2}, segments: []models.Segment{graph[0][1].segment}, price: 200, minSeats: 3}
+	split := path{stops: []int{0, 1, 2}, segments: []models.Segment{graph[0][0].segment, graph[1][0].segment}, price: 200, minSeats: 3}
+	transfer := path{stops: []int{0, 1, 2}, segments: []models.Segment{graph[0][0].segment, graph[1][1].segment}, price: 250, minSeats: 3}
+
+	r := ranker{objective: models.ObjectiveChanges, limit: 2}
+	plans := []path{
+		seatPlan(transfer, []map[seat]bool{{a: true}, {b: true}}),
+		seatPlan(split, []map[seat]bool{{a: true}, {a: true}}),
+		seatPlan(direct, []map[seat]bool{{a: true}}),
+	}
+	got := keys(r.pick(plans))
+	want := []string{"route:0>2|", "route:0>1|later:1>2|"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
@@ -1,25 +1,46 @@
 package segmentation
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"strconv"
+	"sync"
 	"time"
 
 	"github.com/bxxf/regiojet-watchdog/internal/client"
+	"github.com/bxxf/regiojet-watchdog/internal/config"
 	"github.com/bxxf/regiojet-watchdog/internal/constants"
 	"github.com/bxxf/regiojet-watchdog/internal/models"
 )
 
-const timeFormat = "15:04:05.000"
+const (
+	// requestsPerPair is how many requests to the RegioJet API checking a
+	// pair of stops takes, the search between them and the details of the
+	// route.
+	requestsPerPair = 2
+	// requestsPerSeats is how many requests fetching the free seats of a
+	// segment takes, one for every seat class.
+	requestsPerSeats = 3
+)
 
+// SegmentationService finds alternatives to a sold out connection: routes of
+// the same train split into segments that have free seats, possibly changing
+// to other trains for a part of the way.
 type SegmentationService struct {
 	trainClient *client.TrainClient
 	constants   map[string]string
+	workers     int
+	objective   string
+	limit       int
+	minTransfer time.Duration
+	// budget is how many requests to the RegioJet API a search may make,
+	// zero meaning no limit.
+	budget int
 }
 
-func NewSegmentationService(trainClient *client.TrainClient, constantsClient *constants.ConstantsClient) (*SegmentationService, error) {
-	constMap, err := constantsClient.FetchConstants()
+func NewSegmentationService(config config.Config, trainClient *client.TrainClient, constantsClient *constants.ConstantsClient) (*SegmentationService, error) {
+	constMap, err := constantsClient.FetchConstants(context.Background())
 	if err != nil {
 		return nil, fmt.Errorf("failed to fetch constants: %v", err)
 	}
@@ -27,168 +48,284 @@ func NewSegmentationService(trainClient *client.TrainClient, constantsClient *co
 	return &SegmentationService{
 		trainClient: trainClient,
 		constants:   constMap,
+		workers:     config.SegmentationWorkers,
+		objective:   config.AlternativesObjective,
+		limit:       config.AlternativesLimit,
+		minTransfer: config.MinTransferTime,
+		budget:      searchBudget(config),
 	}, nil
 }
 
-func (s *SegmentationService) FindAvailableSegments(routeID, stationFromID, stationToID, departureDate string) ([][]map[string]string, error) {
-	stationsResp, err := s.trainClient.FetchStops(routeID)
+// searchBudget returns how many requests to the RegioJet API a search may
+// make, or zero for no limit. The checks running at the same time share the
+// requests the rate limiter serves within the check timeout.
+func searchBudget(config config.Config) int {
+	if config.UpstreamRequestsPerMinute == 0 || config.CheckTimeout == 0 {
+		return 0
+	}
+	checks := config.CheckWorkers
+	if checks < 1 {
+		checks = 1
+	}
+	return (config.UpstreamBurst + int(float64(config.UpstreamRequestsPerMinute)*config.CheckTimeout.Minutes())) / checks
+}
+
+// FindAvailableSegments searches the segments of the route with free seats
+// that together lead from the first to the second station, returning the best
+// of them by the ranking, or by the configured one when it is nil. Segments of
+// other trains of the day between the stops of the route are combined with
+// them, changing trains with at least the configured transfer time, as long as
+// the route is taken for at least one segment. Every segment names a seat,
+// kept for as long as it is free. The search stops when ctx is done.
+func (s *SegmentationService) FindAvailableSegments(ctx context.Context, routeID, stationFromID, stationToID, departureDate string, ranking *models.Ranking) ([]models.SegmentPath, error) {
+	stationsResp, err := s.trainClient.FetchStops(ctx, routeID)
 	if err != nil {
 		return nil, fmt.Errorf("failed to fetch stops: %v", err)
 	}
 
-	var currentStation models.Stop
-	for _, station := range stationsResp.Stations {
-		if strconv.Itoa(station.StationID) == stationFromID {
-			currentStation = station
-			break
-		}
+	stops, err := stopsBetween(stationsResp.Stations, stationFromID, stationToID)
+	if err != nil {
+		return nil, err
 	}
 
-	paths, err := s.findPath(currentStation, stationToID, stationsResp.Stations, departureDate)
+	graph, err := s.buildGraph(ctx, routeID, stops, departureDate)
 	if err != nil {
 		return nil, fmt.Errorf("failed to find path: %v", err)
 	}
 
-	return s.formatPaths(paths), nil
-}
-
-func (s *SegmentationService) formatPaths(paths [][]map[string]interface{}) [][]map[string]string {
-	var allPaths [][]map[string]string
+	r := s.ranker(ranking)
+	paths := r.rank(graph, s.minTransfer)
+	if len(paths) == 0 {
+		return []models.SegmentPath{}, nil
+	}
 
-	for _, path := range paths {
-		var onePath []map[string]string
-		var totalPrice float64
+	legs, err := s.freeSeatsByLeg(ctx, routeID, stops)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find seats: %v", err)
+	}
 
-		for _, segment := range path {
-			niceSegment := make(map[string]string)
-			fromStationName, ok := s.constants[segment["FromStationID"].(string)]
-			if !ok {
-				log.Printf("Station ID not found in constants: %v", segment["FromStationID"])
-				continue
-			}
+	plans := make([]path, 0, len(paths))
+	for _, p := range paths {
+		plans = append(plans, seatPlan(p, s.segmentSeats(ctx, routeID, p, legs)))
+	}
 
-			toStationName, ok := s.constants[segment["ToStationID"].(string)]
-			if !ok {
-				log.Printf("Station ID not found in constants: %v", segment["ToStationID"])
-				continue
-			}
+	alternatives := []models.SegmentPath{}
+	for _, p := range r.pick(plans) {
+		alternatives = append(alternatives, p.segmentPath())
+	}
+	return alternatives, nil
+}
 
-			departureTime, err := time.Parse(time.RFC3339, segment["DepartureTime"].(string))
-			if err != nil {
-				log.Printf("Failed to parse departure time: %v", err)
-				continue
+// stopsBetween returns the stops of the timetable from the first station to
+// the next stop at the second one.
+func stopsBetween(stations []models.Stop, stationFromID, stationToID string) ([]models.Stop, error) {
+	for i, station := range stations {
+		if strconv.Itoa(station.StationID) != stationFromID {
+			continue
+		}
+		for j := i + 1; j < len(stations); j++ {
+			if strconv.Itoa(stations[j].StationID) == stationToID {
+				return stations[i : j+1], nil
 			}
+		}
+		break
+	}
+	return nil, fmt.Errorf("route does not go from station %s to station %s", stationFromID, stationToID)
+}
 
-			arrivalTime, err := time.Parse(time.RFC3339, segment["ArrivalTime"].(string))
-			if err != nil {
-				log.Printf("Failed to parse arrival time: %v", err)
-				continue
-			}
+// stopPairs returns the pairs of the stops to check, all of them when the
+// budget allows. Otherwise it keeps the neighbouring stops, which every path
+// can be built from, and then the farthest apart, which take the fewest
+// changes, as long as the requests last. Requests are left for the timetable,
+// the free seats of every leg and those of about as many segments on other
+// trains.
+func (s *SegmentationService) stopPairs(stops int) [][2]int {
+	var pairs [][2]int
+	for i := 0; i+1 < stops; i++ {
+		pairs = append(pairs, [2]int{i, i + 1})
+	}
+	for span := stops - 1; span > 1; span-- {
+		for i := 0; i+span < stops; i++ {
+			pairs = append(pairs, [2]int{i, i + span})
+		}
+	}
+	if s.budget == 0 {
+		return pairs
+	}
 
-			niceSegment["from"] = fromStationName
-			niceSegment["to"] = toStationName
-			niceSegment["price"] = fmt.Sprintf("%.2f", segment["Price"].(float64))
-			niceSegment["departureTime"] = departureTime.Format("15:04")
-			niceSegment["arrivalTime"] = arrivalTime.Format("15:04")
-			niceSegment["freeSeats"] = fmt.Sprintf("%d", segment["FreeSeats"].(int))
-			niceSegment["departureDate"] = segment["DepartureDate"].(string)
+	limit := (s.budget - 1 - requestsPerSeats*(2*stops-1)) / requestsPerPair
+	if limit < stops-1 {
+		limit = stops - 1
+	}
+	if limit < len(pairs) {
+		log.Printf("Checking %d of %d pairs of %d stops within %d requests", limit, len(pairs), stops, s.budget)
+		pairs = pairs[:limit]
+	}
+	return pairs
+}
 
-			totalPrice += segment["Price"].(float64)
-			onePath = append(onePath, niceSegment)
+// buildGraph checks the pairs of the stops once, concurrently. graph[i] are
+// the segments with free seats from stop i to a later stop, of the route and
+// of the other trains going there directly.
+func (s *SegmentationService) buildGraph(ctx context.Context, routeID string, stops []models.Stop, departureDate string) ([][]edge, error) {
+	pairs := s.stopPairs(len(stops))
+
+	found := make([][]edge, len(pairs))
+	err := s.parallel(ctx, len(pairs), func(n int) {
+		// every job writes its own edges
+		i, j := pairs[n][0], pairs[n][1]
+		segment, err := s.checkSegment(ctx, routeID, stops[i], stops[j], departureDate)
+		if err == nil {
+			found[n] = append(found[n], edge{to: j, segment: *segment, onRoute: true})
 		}
-		onePath = append(onePath, map[string]string{"totalPrice": fmt.Sprintf("%.2f", totalPrice)})
-		allPaths = append(allPaths, onePath)
+		for _, other := range s.otherTrains(ctx, routeID, stops[i], stops[j], departureDate) {
+			found[n] = append(found[n], edge{to: j, segment: other})
+		}
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	return allPaths
+	graph := make([][]edge, len(stops))
+	for n, edges := range found {
+		graph[pairs[n][0]] = append(graph[pairs[n][0]], edges...)
+	}
+	return graph, nil
 }
 
-func (s *SegmentationService) findPath(currentStation models.Stop, targetStationID string, stations []models.Stop, departureDate string) ([][]map[string]interface{}, error) {
+// parallel runs job(0) to job(n-1) on the workers of the service and waits for
+// them. It stops handing out jobs when ctx is done.
+func (s *SegmentationService) parallel(ctx context.Context, n int, job func(n int)) error {
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < s.workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for n := range jobs {
+				job(n)
+			}
+		}()
+	}
 
-	paths := make([][]map[string]interface{}, 0)
-	currPath := make([]map[string]interface{}, 0)
-	visited := make(map[string]bool)
+send:
+	for i := 0; i < n; i++ {
+		select {
+		case jobs <- i:
+		case <-ctx.Done():
+			break send
+		}
+	}
+	close(jobs)
+	wg.Wait()
 
-	s.findPathRecursive(currentStation, targetStationID, stations, currPath, &paths, visited, departureDate, 0)
-	return paths, nil
+	return ctx.Err()
 }
 
-func (s *SegmentationService) findPathRecursive(currentStation models.Stop, targetStationID string, stations []models.Stop, currPath []map[string]interface{}, paths *[][]map[string]interface{}, visited map[string]bool, departureDate string, index int) {
-	if strconv.Itoa(currentStation.StationID) == targetStationID {
-		newPath := append([]map[string]interface{}{}, currPath...)
-		*paths = append(*paths, newPath)
-		return
-	}
+func (s *SegmentationService) checkSegment(ctx context.Context, routeID string, currentStation, nextStation models.Stop, departureDate string) (*models.Segment, error) {
+	fromStationID := strconv.Itoa(currentStation.StationID)
+	toStationID := strconv.Itoa(nextStation.StationID)
 
-	visited[strconv.Itoa(currentStation.StationID)] = true
+	routes, err := s.trainClient.FetchRoutes(ctx, fromStationID, toStationID, departureDate, "CZK")
+	if err != nil {
+		log.Println("Failed to fetch routes:", err)
+		return nil, err
+	}
 
-	var currStation models.Stop = currentStation
-	for _, station := range stations {
-		if station.Index < currStation.Index {
+	for _, route := range routes {
+		// other trains departing at the same time are not the route
+		if route.ID != routeID {
 			continue
 		}
-		if strconv.Itoa(station.StationID) == strconv.Itoa(currentStation.StationID) {
-			currStation = station
-			break
+		rID, _ := strconv.Atoi(route.ID)
+		details, err := s.trainClient.GetRouteDetails(ctx, rID, fromStationID, toStationID)
+		if err != nil {
+			log.Println("Failed to fetch free seats:", err)
+			continue
 		}
-	}
-
-	for _, nextStation := range stations {
-
-		if visited[strconv.Itoa(nextStation.StationID)] {
+		if details.FreeSeatsCount == 0 {
 			continue
 		}
 
-		if nextStation.Index > currStation.Index {
-			segment, err := s.checkSegment(currentStation, nextStation, departureDate)
-			if err == nil && segment["FreeSeats"].(int) > 0 {
-				currPath = append(currPath, segment)
-				s.findPathRecursive(nextStation, targetStationID, stations, currPath, paths, visited, departureDate, index+1)
-				currPath = currPath[:len(currPath)-1] // Remove the last segment to backtrack
-			}
+		departureTime, err := time.Parse(time.RFC3339, details.DepartureTime)
+		if err != nil {
+			log.Println("Failed to parse departure time:", err)
+			continue
+		}
+		arrivalTime, err := time.Parse(time.RFC3339, details.ArrivalTime)
+		if err != nil {
+			log.Println("Failed to parse arrival time:", err)
+			continue
 		}
+
+		return &models.Segment{
+			RouteID:         route.ID,
+			FromStationID:   fromStationID,
+			FromStationName: s.stationName(fromStationID),
+			ToStationID:     toStationID,
+			ToStationName:   s.stationName(toStationID),
+			DepartureTime:   departureTime,
+			ArrivalTime:     arrivalTime,
+			Price:           details.PriceFrom,
+			FreeSeats:       details.FreeSeatsCount,
+		}, nil
 	}
-	visited[strconv.Itoa(currentStation.StationID)] = false
+
+	return nil, fmt.Errorf("No free seats available from station %s to station %s", fromStationID, toStationID)
 }
 
-func (s *SegmentationService) checkSegment(currentStation, nextStation models.Stop, departureDate string) (map[string]interface{}, error) {
+// otherTrains returns the segments with free seats from one stop to the other
+// on the direct trains other than the route, found by the same search as
+// checkSegment.
+func (s *SegmentationService) otherTrains(ctx context.Context, routeID string, currentStation, nextStation models.Stop, departureDate string) []models.Segment {
+	fromStationID := strconv.Itoa(currentStation.StationID)
+	toStationID := strconv.Itoa(nextStation.StationID)
 
-	routes, err := s.trainClient.FetchRoutes(strconv.Itoa(currentStation.StationID), strconv.Itoa(nextStation.StationID), departureDate, "CZK")
+	routes, err := s.trainClient.FetchDirectRoutes(ctx, fromStationID, toStationID, departureDate, "CZK")
 	if err != nil {
 		log.Println("Failed to fetch routes:", err)
-		return nil, err
+		return nil
 	}
 
+	var segments []models.Segment
 	for _, route := range routes {
-		parsedCurrentDeparture, _ := time.Parse("15:04:05.000", currentStation.Departure)
-		if route.DepartureTime != parsedCurrentDeparture.Format("15:04") {
-			log.Printf("comparing routes: %s != %s", route.DepartureTime, parsedCurrentDeparture.Format("15:04"))
+		if route.ID == routeID || route.FreeSeatsCount == 0 {
 			continue
 		}
-		rID, _ := strconv.Atoi(route.ID)
-		details, err := s.trainClient.GetRouteDetails(rID, strconv.Itoa(currentStation.StationID), strconv.Itoa(nextStation.StationID))
+
+		departureTime, err := time.Parse(time.RFC3339, route.DepartureTime)
 		if err != nil {
-			log.Println("Failed to fetch free seats:", err)
+			log.Println("Failed to parse departure time:", err)
 			continue
 		}
-
-		var segment map[string]interface{}
-
-		if details.FreeSeatsCount > 0 {
-			segment = map[string]interface{}{
-				"FromStationID": strconv.Itoa(currentStation.StationID),
-				"ToStationID":   strconv.Itoa(nextStation.StationID),
-				"RouteID":       route.ID,
-				"FreeSeats":     details.FreeSeatsCount,
-				"DepartureTime": details.DepartureTime,
-				"ArrivalTime":   details.ArrivalTime,
-				"DepartureDate": departureDate,
-				"Price":         details.PriceFrom,
-			}
-			return segment, nil
+		arrivalTime, err := time.Parse(time.RFC3339, route.ArrivalTime)
+		if err != nil {
+			log.Println("Failed to parse arrival time:", err)
+			continue
 		}
 
+		segments = append(segments, models.Segment{
+			RouteID:         route.ID,
+			FromStationID:   fromStationID,
+			FromStationName: s.stationName(fromStationID),
+			ToStationID:     toStationID,
+			ToStationName:   s.stationName(toStationID),
+			DepartureTime:   departureTime,
+			ArrivalTime:     arrivalTime,
+			Price:           route.PriceFrom,
+			FreeSeats:       route.FreeSeatsCount,
+		})
 	}
+	return segments
+}
 
-	return nil, fmt.Errorf("No free seats available from station %s to station %s", strconv.Itoa(currentStation.StationID), strconv.Itoa(nextStation.StationID))
+// stationName returns the name of the station, or its ID when the constants
+// do not know it.
+func (s *SegmentationService) stationName(stationID string) string {
+	name, ok := s.constants[stationID]
+	if !ok {
+		log.Printf("Station ID not found in constants: %v", stationID)
+		return stationID
+	}
+	return name
 }
@@ -0,0 +1,158 @@
+package segmentation
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/bxxf/regiojet-watchdog/internal/client"
+	"github.com/bxxf/regiojet-watchdog/internal/config"
+	"github.com/bxxf/regiojet-watchdog/internal/constants"
+	"github.com/bxxf/regiojet-watchdog/internal/fakeregiojet"
+	"github.com/bxxf/regiojet-watchdog/internal/models"
+	"go.uber.org/zap"
+)
+
+// line returns a fixture of the trains, each with two seats, running through
+// the given number of stops with the same timetable.
+func line(stops int, trains ...fakeregiojet.Train) *fakeregiojet.Fixture {
+	fixture := &fakeregiojet.Fixture{}
+	var timetable []fakeregiojet.TrainStop
+	departure := time.Date(2026, 10, 17, 6, 0, 0, 0, time.UTC)
+	for k := 0; k < stops; k++ {
+		station := int64(1000 + k)
+		fixture.Stations = append(fixture.Stations, fakeregiojet.Station{ID: station, Name: fmt.Sprintf("Station %d", k), City: "City"})
+
+		stop := fakeregiojet.TrainStop{StationID: station}
+		if k > 0 {
+			stop.Arrival = departure.Add(time.Duration(k)*10*time.Minute - time.Minute).Format("15:04")
+		}
+		if k < stops-1 {
+			stop.Departure = departure.Add(time.Duration(k) * 10 * time.Minute).Format("15:04")
+		}
+		timetable = append(timetable, stop)
+	}
+
+	for _, train := range trains {
+		train.PricePerLeg = 50
+		train.Stops = timetable
+		train.Vehicles = []fakeregiojet.Vehicle{{Number: 1, SeatClass: "C0", Seats: 2}}
+		fixture.Trains = append(fixture.Trains, train)
+	}
+	return fixture
+}
+
+// newTestService returns a service searching the fixture, and the number of
+// requests made to it.
+func newTestService(t *testing.T, fixture *fakeregiojet.Fixture, cfg config.Config) (*SegmentationService, *int64) {
+	var requests int64
+	fake := fakeregiojet.NewServer(fixture)
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&requests, 1)
+		fake.ServeHTTP(w, r)
+	}))
+	t.Cleanup(api.Close)
+
+	cfg.RegioJetAPIURL = api.URL
+	cfg.UpstreamCacheTTL = time.Minute
+	cfg.UpstreamTimeout = 10 * time.Second
+	cfg.SegmentationWorkers = 4
+	cfg.AlternativesObjective = models.ObjectiveChanges
+	cfg.AlternativesLimit = 3
+	logger := zap.NewNop()
+	s, err := NewSegmentationService(cfg, client.NewTrainClient(logger, cfg), constants.NewConstantsClient(logger, cfg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	atomic.StoreInt64(&requests, 0)
+	return s, &requests
+}
+
+// search returns the alternatives of the train tomorrow from the first to the
+// last of the stops.
+func search(t *testing.T, s *SegmentationService, train int64, stops int) []models.SegmentPath {
+	tomorrow := time.Now().AddDate(0, 0, 1)
+	date, _ := strconv.ParseInt(tomorrow.Format("20060102"), 10, 64)
+	routeID := strconv.FormatInt(train*100000000+date, 10)
+	paths, err := s.FindAvailableSegments(context.Background(), routeID, "1000", strconv.Itoa(1000+stops-1), tomorrow.Format("02.01.2006"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return paths
+}
+
+func TestFindAvailableSegmentsWithinBudget(t *testing.T) {
+	const stops = 20
+
+	// the two seats are booked before and after the middle stop
+	fixture := line(stops, fakeregiojet.Train{ID: 2020, Bookings: []fakeregiojet.Booking{
+		{Vehicle: 1, Seats: []int{1}, From: 0, To: stops / 2},
+		{Vehicle: 1, Seats: []int{2}, From: stops / 2, To: stops - 1},
+	}})
+	cfg := config.Config{
+		UpstreamRequestsPerMinute: 12000,
+		UpstreamBurst:             10,
+		CheckWorkers:              1,
+		CheckTimeout:              time.Second,
+		MinTransferTime:           5 * time.Minute,
+	}
+	s, requests := newTestService(t, fixture, cfg)
+	budget := searchBudget(cfg)
+	if pairs := stops * (stops - 1) / 2; budget >= pairs*requestsPerPair {
+		t.Fatalf("the budget of %d requests is enough for all %d pairs", budget, pairs)
+	}
+
+	paths := search(t, s, 2020, stops)
+	if made := atomic.LoadInt64(requests); made > int64(budget) {
+		t.Errorf("made %d requests, more than the budget of %d", made, budget)
+	}
+	if len(paths) == 0 {
+		t.Fatal("found no alternatives")
+	}
+	if got := paths[0].SeatChanges; got != 1 {
+		t.Errorf("got %d seat changes for the best alternative, want 1", got)
+	}
+}
+
+func TestOtherTrainDepartingWithTheRoute(t *testing.T) {
+	// the route is sold out from the middle stop on, where another train
+	// departs at the same time
+	fixture := line(3,
+		fakeregiojet.Train{ID: 3030, Bookings: []fakeregiojet.Booking{{Vehicle: 1, From: 1, To: 2}}},
+		fakeregiojet.Train{ID: 3031},
+	)
+	s, _ := newTestService(t, fixture, config.Config{})
+
+	paths := search(t, s, 3030, 3)
+	if len(paths) == 0 {
+		t.Fatal("found no alternatives")
+	}
+	last := paths[0].Segments[len(paths[0].Segments)-1]
+	if !strings.HasPrefix(last.RouteID, "3031") || paths[0].Transfers != 1 {
+		t.Errorf("got %+v, want to change to the other train at the middle stop", paths[0])
+	}
+}
+
+func TestSearchBudget(t *testing.T) {
+	cases := []struct {
+		name   string
+		config config.Config
+		want   int
+	}{
+		{"no rate limit", config.Config{CheckWorkers: 4, CheckTimeout: time.Minute}, 0},
+		{"no timeout", config.Config{UpstreamRequestsPerMinute: 120, CheckWorkers: 4}, 0},
+		{"one worker", config.Config{UpstreamRequestsPerMinute: 120, UpstreamBurst: 10, CheckWorkers: 1, CheckTimeout: 2 * time.Minute}, 250},
+		{"shared by the workers", config.Config{UpstreamRequestsPerMinute: 120, UpstreamBurst: 10, CheckWorkers: 4, CheckTimeout: 2 * time.Minute}, 62},
+	}
+	for _, c := range cases {
+		if got := searchBudget(c.config); got != c.want {
+			t.Errorf("%s: got %d, want %d", c.name, got, c.want)
+		}
+	}
+}
@@ -3,44 +3,90 @@ package server
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
+	"net"
 	"net/http"
+	"net/url"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/bxxf/regiojet-watchdog/internal/client"
 	"github.com/bxxf/regiojet-watchdog/internal/config"
 	"github.com/bxxf/regiojet-watchdog/internal/constants"
 	"github.com/bxxf/regiojet-watchdog/internal/database"
+	"github.com/bxxf/regiojet-watchdog/internal/discord"
+	"github.com/bxxf/regiojet-watchdog/internal/models"
+	"github.com/bxxf/regiojet-watchdog/internal/notifier"
+	"github.com/bxxf/regiojet-watchdog/internal/segmentation"
+	"github.com/bxxf/regiojet-watchdog/internal/slack"
+	"github.com/bxxf/regiojet-watchdog/internal/telegram"
 	"github.com/google/uuid"
 	"go.uber.org/fx"
 )
 
+// minCheckIntervalSeconds keeps single watchdogs from flooding the RegioJet
+// API.
+const minCheckIntervalSeconds = 30
+
+// readHeaderTimeout drops clients that do not finish their request headers.
+const readHeaderTimeout = 10 * time.Second
+
 type Server struct {
-	trainClient *client.TrainClient
-	config      config.Config
-	constants   map[string]string
-	database    *database.DatabaseClient
+	trainClient         *client.TrainClient
+	config              config.Config
+	constants           map[string]string
+	store               database.WatchdogStore
+	notifications       database.NotificationStore
+	notificationService *notifier.NotificationService
+	segmentationService *segmentation.SegmentationService
+	httpServer          *http.Server
 }
 
-func NewServer(trainClient *client.TrainClient, config config.Config, constantsClient *constants.ConstantsClient, database *database.DatabaseClient) *Server {
-	constMap, _ := constantsClient.FetchConstants()
+func NewServer(trainClient *client.TrainClient, config config.Config, constantsClient *constants.ConstantsClient, store database.WatchdogStore, notifications database.NotificationStore, notificationService *notifier.NotificationService, segmentationService *segmentation.SegmentationService) *Server {
+	constMap, _ := constantsClient.FetchConstants(context.Background())
 	return &Server{
-		trainClient: trainClient,
-		config:      config,
-		constants:   constMap,
-		database:    database,
+		trainClient:         trainClient,
+		config:              config,
+		constants:           constMap,
+		store:               store,
+		notifications:       notifications,
+		notificationService: notificationService,
+		segmentationService: segmentationService,
 	}
 }
 
-func (s *Server) run() {
-	http.HandleFunc("/routes", s.getRoutesHandler)
-	http.HandleFunc(("/watchdog"), s.watchdogHandler)
-	http.HandleFunc("/constants", s.constantsHandler)
+func (s *Server) start() error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/routes", s.getRoutesHandler)
+	mux.HandleFunc("/alternatives", s.alternativesHandler)
+	mux.HandleFunc("/watchdog", s.watchdogHandler)
+	mux.HandleFunc("/watchdogs", s.watchdogsHandler)
+	mux.HandleFunc("/watchdogs/", s.watchdogByIDHandler)
+	mux.HandleFunc("/deadletters", s.deadLettersHandler)
+	mux.HandleFunc("/deadletters/", s.deadLetterByIDHandler)
+	mux.HandleFunc("/constants", s.constantsHandler)
 
 	port := s.config.Port
+	listener, err := net.Listen("tcp", ":"+port)
+	if err != nil {
+		return fmt.Errorf("failed to listen on port %s: %v", port, err)
+	}
+
+	s.httpServer = &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: readHeaderTimeout,
+	}
+	go func() {
+		if err := s.httpServer.Serve(listener); !errors.Is(err, http.ErrServerClosed) {
+			log.Fatal(err)
+		}
+	}()
+
 	log.Printf("Server is running on port %s...\n", port)
-	log.Fatal(http.ListenAndServe(":"+port, nil))
+	return nil
 }
 
 func (s *Server) getRoutesHandler(w http.ResponseWriter, r *http.Request) {
@@ -53,9 +99,9 @@ func (s *Server) getRoutesHandler(w http.ResponseWriter, r *http.Request) {
 	stationToID := r.URL.Query().Get("stationToID")
 	departureDateInput := r.URL.Query().Get("departureDate")
 
-	routes, err := s.trainClient.FetchRoutes(stationFromID, stationToID, departureDateInput, "CZK")
+	routes, err := s.trainClient.FetchRoutes(client.WithPriority(r.Context(), client.PriorityInteractive), stationFromID, stationToID, departureDateInput, "CZK")
 	if err != nil {
-		http.Error(w, "Failed to fetch routes", http.StatusInternalServerError)
+		http.Error(w, "Failed to fetch routes", upstreamStatus(err))
 		log.Println("Failed to fetch routes:", err)
 		return
 	}
@@ -66,12 +112,232 @@ func (s *Server) getRoutesHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// alternativesHandler searches the alternative routes of a connection, ranked
+// as the query asks or else as configured.
+func (s *Server) alternativesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	watchdog := models.Watchdog{
+		StationFromID: query.Get("stationFromID"),
+		StationToID:   query.Get("stationToID"),
+		RouteID:       query.Get("routeID"),
+	}
+	if watchdog.StationFromID == "" || watchdog.StationToID == "" || watchdog.RouteID == "" {
+		http.Error(w, "stationFromID, stationToID and routeID are required", http.StatusBadRequest)
+		return
+	}
+	ranking, err := rankingQuery(query)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	_, departureTime, ok := s.resolveRoute(w, r, watchdog)
+	if !ok {
+		return
+	}
+
+	ctx := client.WithPriority(r.Context(), client.PriorityInteractive)
+	paths, err := s.segmentationService.FindAvailableSegments(ctx, watchdog.RouteID, watchdog.StationFromID, watchdog.StationToID, departureTime.Format("02.01.2006"), ranking)
+	if err != nil {
+		http.Error(w, "Failed to find alternatives", http.StatusInternalServerError)
+		log.Println("Failed to find alternatives:", err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, paths)
+}
+
+// rankingQuery reads the objective, limit and changesWeight, priceWeight and
+// seatsWeight query parameters of an alternatives search.
+func rankingQuery(query url.Values) (*models.Ranking, error) {
+	ranking := models.Ranking{Objective: query.Get("objective")}
+	if limit := query.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil {
+			return nil, errors.New("limit must be a number")
+		}
+		// zero would silently mean the default, leave the parameter out instead
+		if n < 1 || n > models.MaxAlternatives {
+			return nil, fmt.Errorf("limit must be between 1 and %d", models.MaxAlternatives)
+		}
+		ranking.Limit = n
+	}
+
+	var weights models.RankingWeights
+	for _, weight := range []struct {
+		name  string
+		value *float64
+	}{
+		{"changesWeight", &weights.Changes},
+		{"priceWeight", &weights.Price},
+		{"seatsWeight", &weights.Seats},
+	} {
+		if value := query.Get(weight.name); value != "" {
+			f, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				return nil, fmt.Errorf("%s must be a number", weight.name)
+			}
+			*weight.value = f
+			ranking.Weights = &weights
+		}
+	}
+
+	if err := segmentation.ValidateRanking(ranking); err != nil {
+		return nil, err
+	}
+	return &ranking, nil
+}
+
+type watchdogRequest struct {
+	targetShorthands
+	StationFromID string                      `json:"stationFromID"`
+	StationToID   string                      `json:"stationToID"`
+	RouteID       string                      `json:"routeID"`
+	Targets       []models.NotificationTarget `json:"targets"`
+	SeatClass     string                      `json:"seatClass"`
+	Passengers    int                         `json:"passengers"`
+	Owner         string                      `json:"owner"`
+	CheckInterval int                         `json:"checkIntervalSeconds"`
+	Ranking       *models.Ranking             `json:"ranking"`
+}
+
+type watchdogResponse struct {
+	models.Watchdog
+	RouteDetails *models.RouteDetails `json:"routeDetails,omitempty"`
+}
+
 func (s *Server) watchdogHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	s.createWatchdog(w, r)
+}
+
+func (s *Server) watchdogsHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		watchdogs, err := s.store.ListWatchdogs(r.Context())
+		if err != nil {
+			http.Error(w, "Failed to list watchdogs", http.StatusInternalServerError)
+			log.Println("Failed to list watchdogs:", err)
+			return
+		}
+
+		if owner := r.URL.Query().Get("owner"); owner != "" {
+			owned := []models.Watchdog{}
+			for _, watchdog := range watchdogs {
+				if watchdog.Owner == owner {
+					owned = append(owned, watchdog)
+				}
+			}
+			watchdogs = owned
+		}
+		for i := range watchdogs {
+			watchdogs[i] = s.redacted(watchdogs[i])
+		}
+		writeJSON(w, http.StatusOK, watchdogs)
+	case http.MethodPost:
+		s.createWatchdog(w, r)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) watchdogByIDHandler(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/watchdogs/")
+	if id == "" || strings.Contains(id, "/") {
+		http.NotFound(w, r)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		watchdog, ok := s.getWatchdog(w, r, id)
+		if !ok {
+			return
+		}
+		writeJSON(w, http.StatusOK, watchdogResponse{Watchdog: s.redacted(*watchdog)})
+	case http.MethodPatch:
+		s.updateWatchdog(w, r, id)
+	case http.MethodDelete:
+		err := s.store.DeleteWatchdog(r.Context(), id)
+		if errors.Is(err, database.ErrWatchdogNotFound) {
+			http.Error(w, "Watchdog not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Failed to delete watchdog", http.StatusInternalServerError)
+			log.Println("Failed to delete watchdog:", err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) createWatchdog(w http.ResponseWriter, r *http.Request) {
+	var body watchdogRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "Failed to parse request body", http.StatusBadRequest)
+		log.Println("Failed to parse request body:", err)
+		return
+	}
+
+	watchdog := models.Watchdog{
+		Version:       models.WatchdogVersion,
+		ID:            uuid.New().String(),
+		StationFromID: body.StationFromID,
+		StationToID:   body.StationToID,
+		RouteID:       body.RouteID,
+		Targets:       append(body.Targets, body.shorthandTargets()...),
+		SeatClass:     body.SeatClass,
+		Passengers:    body.Passengers,
+		Owner:         body.Owner,
+		Ranking:       body.Ranking,
+		CreatedAt:     time.Now(),
+
+		CheckIntervalSeconds: body.CheckInterval,
+	}
+
+	if !s.validateWatchdog(w, watchdog) {
+		return
+	}
+
+	routeDetails, departureTime, ok := s.resolveRoute(w, r, watchdog)
+	if !ok {
+		return
+	}
+	watchdog.DepartureTime = departureTime
+	watchdog.ExpiresAt = departureTime
+
+	if err := s.store.SaveWatchdog(r.Context(), watchdog); err != nil {
+		http.Error(w, "Failed to save watchdog", http.StatusInternalServerError)
+		log.Println("Failed to save watchdog:", err)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, watchdogResponse{Watchdog: s.redacted(watchdog), RouteDetails: routeDetails})
+}
+
+func (s *Server) updateWatchdog(w http.ResponseWriter, r *http.Request, id string) {
 	body := struct {
-		StationFromID string `json:"stationFromID"`
-		StationToID   string `json:"stationToID"`
-		RouteID       string `json:"routeID"`
-		WebhookURL    string `json:"webhookURL"`
+		targetShorthands
+		StationFromID *string                      `json:"stationFromID"`
+		StationToID   *string                      `json:"stationToID"`
+		RouteID       *string                      `json:"routeID"`
+		Targets       *[]models.NotificationTarget `json:"targets"`
+		SeatClass     *string                      `json:"seatClass"`
+		Passengers    *int                         `json:"passengers"`
+		Owner         *string                      `json:"owner"`
+		CheckInterval *int                         `json:"checkIntervalSeconds"`
+		Ranking       *models.Ranking              `json:"ranking"`
 	}{}
 
 	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
@@ -80,30 +346,318 @@ func (s *Server) watchdogHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	routeInt, _ := strconv.Atoi(body.RouteID)
-	routeDetails, err := s.trainClient.GetRouteDetails(routeInt, body.StationFromID, body.StationToID)
+	// patch sets the fields of the body and reports whether the route changed.
+	patch := func(watchdog *models.Watchdog) bool {
+		routeChanged := false
+		for _, field := range []struct {
+			value  *string
+			target *string
+		}{
+			{body.StationFromID, &watchdog.StationFromID},
+			{body.StationToID, &watchdog.StationToID},
+			{body.RouteID, &watchdog.RouteID},
+		} {
+			if field.value != nil && *field.value != *field.target {
+				*field.target = *field.value
+				routeChanged = true
+			}
+		}
+		if body.Targets != nil || len(body.shorthandTargets()) > 0 {
+			var targets []models.NotificationTarget
+			if body.Targets != nil {
+				targets = *body.Targets
+			}
+			watchdog.Targets = append(targets, body.shorthandTargets()...)
+		}
+		if body.SeatClass != nil {
+			watchdog.SeatClass = *body.SeatClass
+		}
+		if body.Passengers != nil {
+			watchdog.Passengers = *body.Passengers
+		}
+		if body.Owner != nil {
+			watchdog.Owner = *body.Owner
+		}
+		if body.CheckInterval != nil {
+			watchdog.CheckIntervalSeconds = *body.CheckInterval
+		}
+		if body.Ranking != nil {
+			// an empty ranking goes back to the configured one
+			watchdog.Ranking = body.Ranking
+			if *body.Ranking == (models.Ranking{}) {
+				watchdog.Ranking = nil
+			}
+		}
+		return routeChanged
+	}
+
+	current, ok := s.getWatchdog(w, r, id)
+	if !ok {
+		return
+	}
+	watchdog := *current
+	routeChanged := patch(&watchdog)
+	if !s.validateWatchdog(w, watchdog) {
+		return
+	}
+
+	// The expiration follows the departure, so it only moves when the route does.
+	var routeDetails *models.RouteDetails
+	var departureTime time.Time
+	if routeChanged {
+		var ok bool
+		routeDetails, departureTime, ok = s.resolveRoute(w, r, watchdog)
+		if !ok {
+			return
+		}
+	}
+
+	// The watchdog is patched again as stored now, so a check recorded since
+	// it was read above is kept.
+	err := s.store.UpdateWatchdog(r.Context(), id, func(stored *models.Watchdog) error {
+		if routeChanged && !sameRoute(*stored, *current) {
+			return errWatchdogChanged
+		}
+		patch(stored)
+		if routeChanged {
+			stored.DepartureTime = departureTime
+			stored.ExpiresAt = departureTime
+			stored.LastSnapshot = nil
+		}
+		watchdog = *stored
+		return nil
+	})
+	if errors.Is(err, database.ErrWatchdogNotFound) {
+		http.Error(w, "Watchdog not found", http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, errWatchdogChanged) {
+		http.Error(w, "Watchdog route changed during the update, try again", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to save watchdog", http.StatusInternalServerError)
+		log.Println("Failed to save watchdog:", err)
+		return
+	}
+	if routeChanged {
+		// check the new route right away
+		if err := s.store.ScheduleCheck(r.Context(), watchdog.ID, time.Now()); err != nil {
+			log.Println("Failed to schedule watchdog:", err)
+		}
+	}
+
+	writeJSON(w, http.StatusOK, watchdogResponse{Watchdog: s.redacted(watchdog), RouteDetails: routeDetails})
+}
+
+// errWatchdogChanged is returned when the route a PATCH was resolved against
+// has been changed by another update.
+var errWatchdogChanged = errors.New("watchdog changed during the update")
+
+func sameRoute(a, b models.Watchdog) bool {
+	return a.StationFromID == b.StationFromID && a.StationToID == b.StationToID && a.RouteID == b.RouteID
+}
+
+func (s *Server) getWatchdog(w http.ResponseWriter, r *http.Request, id string) (*models.Watchdog, bool) {
+	watchdog, err := s.store.GetWatchdog(r.Context(), id)
+	if errors.Is(err, database.ErrWatchdogNotFound) {
+		http.Error(w, "Watchdog not found", http.StatusNotFound)
+		return nil, false
+	}
+	if err != nil {
+		http.Error(w, "Failed to fetch watchdog", http.StatusInternalServerError)
+		log.Println("Failed to fetch watchdog:", err)
+		return nil, false
+	}
+	return watchdog, true
+}
+
+// redacted returns the watchdog with the secret settings of its targets
+// masked, to be shown in responses.
+func (s *Server) redacted(watchdog models.Watchdog) models.Watchdog {
+	watchdog.Targets = s.notificationService.Redact(watchdog.Targets)
+	return watchdog
+}
+
+func (s *Server) validateWatchdog(w http.ResponseWriter, watchdog models.Watchdog) bool {
+	if watchdog.StationFromID == "" || watchdog.StationToID == "" || watchdog.RouteID == "" {
+		http.Error(w, "stationFromID, stationToID and routeID are required", http.StatusBadRequest)
+		return false
+	}
+	if watchdog.Passengers < 0 {
+		http.Error(w, "passengers must not be negative", http.StatusBadRequest)
+		return false
+	}
+	if watchdog.CheckIntervalSeconds != 0 && watchdog.CheckIntervalSeconds < minCheckIntervalSeconds {
+		http.Error(w, fmt.Sprintf("checkIntervalSeconds must be at least %d", minCheckIntervalSeconds), http.StatusBadRequest)
+		return false
+	}
+	if watchdog.Ranking != nil {
+		if err := segmentation.ValidateRanking(*watchdog.Ranking); err != nil {
+			http.Error(w, "ranking: "+err.Error(), http.StatusBadRequest)
+			return false
+		}
+	}
+	if err := s.notificationService.Validate(watchdog.Targets); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+// targetShorthands are the request fields that each add a single
+// notification target to the watchdog.
+type targetShorthands struct {
+	WebhookURL      string `json:"webhookURL"`
+	TelegramChatID  string `json:"telegramChatID"`
+	SlackWebhookURL string `json:"slackWebhookURL"`
+}
+
+func (t targetShorthands) shorthandTargets() []models.NotificationTarget {
+	var targets []models.NotificationTarget
+	if t.WebhookURL != "" {
+		targets = append(targets, discord.Target(t.WebhookURL))
+	}
+	if t.TelegramChatID != "" {
+		targets = append(targets, telegram.Target(t.TelegramChatID))
+	}
+	if t.SlackWebhookURL != "" {
+		targets = append(targets, slack.Target(t.SlackWebhookURL))
+	}
+	return targets
+}
+
+// resolveRoute fetches the route details of the watched connection and returns
+// when the train departs, which is also when the watchdog expires.
+func (s *Server) resolveRoute(w http.ResponseWriter, r *http.Request, watchdog models.Watchdog) (*models.RouteDetails, time.Time, bool) {
+	routeInt, err := strconv.Atoi(watchdog.RouteID)
+	if err != nil {
+		http.Error(w, "Invalid routeID", http.StatusBadRequest)
+		return nil, time.Time{}, false
+	}
+
+	routeDetails, err := s.trainClient.GetRouteDetails(client.WithPriority(r.Context(), client.PriorityInteractive), routeInt, watchdog.StationFromID, watchdog.StationToID)
+	if errors.Is(err, client.ErrNotFound) {
+		http.Error(w, "Route not found", http.StatusNotFound)
+		return nil, time.Time{}, false
+	}
 	if err != nil {
-		http.Error(w, "Failed to fetch route details", http.StatusInternalServerError)
+		http.Error(w, "Failed to fetch route details", upstreamStatus(err))
 		log.Println("Failed to fetch route details:", err)
+		return nil, time.Time{}, false
+	}
+
+	departureTime, err := time.Parse(time.RFC3339, routeDetails.DepartureTime)
+	if err != nil {
+		http.Error(w, "Failed to parse departure time", http.StatusInternalServerError)
+		log.Println("Failed to parse departure time:", err)
+		return nil, time.Time{}, false
+	}
+
+	if !departureTime.After(time.Now()) {
+		http.Error(w, "Route has already departed", http.StatusBadRequest)
+		return nil, time.Time{}, false
+	}
+
+	return routeDetails, departureTime, true
+}
+
+// upstreamStatus returns the status code of a failed request to the RegioJet
+// API.
+func upstreamStatus(err error) int {
+	var upstreamErr *client.UpstreamError
+	switch {
+	case errors.Is(err, client.ErrCircuitOpen) || errors.Is(err, client.ErrRateLimited):
+		return http.StatusServiceUnavailable
+	case errors.As(err, &upstreamErr):
+		return http.StatusBadGateway
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func (s *Server) deadLettersHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
-	departureTime, _ := time.Parse(time.RFC3339, routeDetails.DepartureTime)
-	departureDuration := departureTime.Sub(time.Now())
 
-	uuid := "watchdog:" + uuid.New().String()
-	s.database.RedisClient.Set(context.Background(), uuid, body.WebhookURL+";;"+body.StationFromID+";;"+body.StationToID+";;"+body.RouteID, departureDuration)
+	deadLetters, err := s.notifications.ListDeadLetters(r.Context())
+	if err != nil {
+		http.Error(w, "Failed to list dead letters", http.StatusInternalServerError)
+		log.Println("Failed to list dead letters:", err)
+		return
+	}
+	for i := range deadLetters {
+		deadLetters[i] = s.redactedNotification(deadLetters[i])
+	}
+	writeJSON(w, http.StatusOK, deadLetters)
+}
 
-	res := struct {
-		Message string `json:"message"`
-	}{
-		Message: "Watchdog set successfully.",
+// deadLetterByIDHandler serves /deadletters/{id} and
+// POST /deadletters/{id}/retry, which queues the notification again.
+func (s *Server) deadLetterByIDHandler(w http.ResponseWriter, r *http.Request) {
+	id, action, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/deadletters/"), "/")
+	if id == "" || (action != "" && action != "retry") {
+		http.NotFound(w, r)
+		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode(res); err != nil {
-		http.Error(w, "Failed to write response", http.StatusInternalServerError)
+	switch {
+	case action == "retry" && r.Method == http.MethodPost:
+		notification, ok := s.getDeadLetter(w, r, id)
+		if !ok {
+			return
+		}
+		if err := s.notificationService.Requeue(r.Context(), *notification); err != nil {
+			http.Error(w, "Failed to retry notification", http.StatusInternalServerError)
+			log.Println("Failed to retry notification:", err)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+	case action == "" && r.Method == http.MethodGet:
+		notification, ok := s.getDeadLetter(w, r, id)
+		if !ok {
+			return
+		}
+		writeJSON(w, http.StatusOK, s.redactedNotification(*notification))
+	case action == "" && r.Method == http.MethodDelete:
+		err := s.notifications.DeleteDeadLetter(r.Context(), id)
+		if errors.Is(err, database.ErrNotificationNotFound) {
+			http.Error(w, "Dead letter not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Failed to delete dead letter", http.StatusInternalServerError)
+			log.Println("Failed to delete dead letter:", err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 	}
+}
 
+// redactedNotification returns the notification with the secret settings of
+// its target and of the targets of its watchdog masked.
+func (s *Server) redactedNotification(notification models.Notification) models.Notification {
+	notification.Watchdog = s.redacted(notification.Watchdog)
+	notification.Target = s.notificationService.Redact([]models.NotificationTarget{notification.Target})[0]
+	return notification
+}
+
+func (s *Server) getDeadLetter(w http.ResponseWriter, r *http.Request, id string) (*models.Notification, bool) {
+	notification, err := s.notifications.GetDeadLetter(r.Context(), id)
+	if errors.Is(err, database.ErrNotificationNotFound) {
+		http.Error(w, "Dead letter not found", http.StatusNotFound)
+		return nil, false
+	}
+	if err != nil {
+		http.Error(w, "Failed to fetch dead letter", http.StatusInternalServerError)
+		log.Println("Failed to fetch dead letter:", err)
+		return nil, false
+	}
+	return notification, true
 }
 
 func (s *Server) constantsHandler(w http.ResponseWriter, r *http.Request) {
@@ -118,12 +672,22 @@ func (s *Server) constantsHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Println("Failed to write response:", err)
+	}
+}
+
 func RegisterServerHooks(lc fx.Lifecycle, server *Server) {
 	lc.Append(fx.Hook{
 		OnStart: func(context.Context) error {
-			go server.run()
-			return nil
+			return server.start()
+		},
+		OnStop: func(ctx context.Context) error {
+			// waits for the requests being handled until ctx is done
+			return server.httpServer.Shutdown(ctx)
 		},
-		OnStop: nil,
 	})
 }
@@ -0,0 +1,246 @@
+package slack
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/bxxf/regiojet-watchdog/internal/models"
+	"github.com/bxxf/regiojet-watchdog/internal/notifier"
+	"go.uber.org/zap"
+)
+
+const (
+	targetType = "slack"
+
+	// Block Kit limits of a single message. Texts are limited in characters,
+	// which counting the bytes of section texts stays within.
+	maxBlocks        = 50
+	maxSectionFields = 10
+	maxSectionText   = 3000
+	maxHeaderText    = 150
+)
+
+// SlackService posts notifications as Block Kit messages to Slack incoming
+// webhooks.
+type SlackService struct {
+	logger *zap.Logger
+	client *http.Client
+}
+
+func NewSlackService(logger *zap.Logger) *SlackService {
+	return &SlackService{
+		logger: logger,
+		client: &http.Client{},
+	}
+}
+
+// Target returns a notification target that posts to the incoming webhook.
+func Target(webhookURL string) models.NotificationTarget {
+	return models.NotificationTarget{
+		Type:     targetType,
+		Settings: map[string]string{"webhookURL": webhookURL},
+	}
+}
+
+func (s *SlackService) Type() string {
+	return targetType
+}
+
+// SecretSettings hides the webhook URL, which anyone can post to.
+func (s *SlackService) SecretSettings() []string {
+	return []string{"webhookURL"}
+}
+
+func (s *SlackService) Validate(target models.NotificationTarget) error {
+	if !strings.HasPrefix(target.Settings["webhookURL"], "https://") {
+		return errors.New("slack target requires an https webhookURL setting")
+	}
+	return nil
+}
+
+func (s *SlackService) NotifyFreeSeats(ctx context.Context, watchdog models.Watchdog, target models.NotificationTarget, freeSeats models.FreeSeatsResponse, routeDetails models.RouteDetails) error {
+	if routeDetails.FreeSeatsCount == 0 {
+		return nil
+	}
+
+	title := fmt.Sprintf("Tickets available (%s -> %s) - %s -> %s [%s]",
+		routeDetails.DepartureCityName,
+		routeDetails.ArrivalCityName,
+		notifier.Clock(routeDetails.DepartureTime),
+		notifier.Clock(routeDetails.ArrivalTime),
+		notifier.Date(routeDetails.DepartureTime),
+	)
+
+	blocks := []block{
+		headerBlock(title),
+		textSection(fmt.Sprintf("Travel Time: %s, Free seats count: *%d*", routeDetails.TravelTime, routeDetails.FreeSeatsCount)),
+	}
+
+	var fields []text
+	for _, vehicle := range notifier.CountFreeSeats(freeSeats) {
+		fields = append(fields, markdown(fmt.Sprintf("*Vehicle Number: %d* (%s)\nNumber of Free Seats: %d", vehicle.VehicleNumber, vehicle.SeatClass, vehicle.FreeSeats)))
+		if len(fields) == maxSectionFields {
+			blocks = append(blocks, block{Type: "section", Fields: fields})
+			fields = nil
+		}
+	}
+	if len(fields) > 0 {
+		blocks = append(blocks, block{Type: "section", Fields: fields})
+	}
+
+	blocks = append(blocks, contextBlock(fmt.Sprintf("Price From: %dCZK, Price To: %dCZK", int(routeDetails.PriceFrom), int(routeDetails.PriceTo))))
+
+	return s.post(ctx, target.Settings["webhookURL"], title, blocks)
+}
+
+func (s *SlackService) NotifySoldOut(ctx context.Context, watchdog models.Watchdog, target models.NotificationTarget, routeDetails models.RouteDetails) error {
+	title := fmt.Sprintf("Sold out (%s -> %s) - %s -> %s [%s]",
+		routeDetails.DepartureCityName,
+		routeDetails.ArrivalCityName,
+		notifier.Clock(routeDetails.DepartureTime),
+		notifier.Clock(routeDetails.ArrivalTime),
+		notifier.Date(routeDetails.DepartureTime),
+	)
+
+	blocks := []block{
+		headerBlock(title),
+		textSection("There are no free seats left, you will be notified when some appear again."),
+	}
+
+	return s.post(ctx, target.Settings["webhookURL"], title, blocks)
+}
+
+func (s *SlackService) NotifyAlternatives(ctx context.Context, watchdog models.Watchdog, target models.NotificationTarget, paths []models.SegmentPath) error {
+	if len(paths) == 0 {
+		return nil
+	}
+
+	from, to, date := notifier.Journey(paths)
+	title := fmt.Sprintf("Alternative routes %s -> %s (%s)", from, to, date)
+
+	blocks := []block{headerBlock(title)}
+	// header, the "more alternatives" note and the footer take three blocks
+	// and every alternative two
+	shown := len(paths)
+	if limit := (maxBlocks - 3) / 2; shown > limit {
+		shown = limit
+	}
+
+	for _, path := range paths[:shown] {
+		blocks = append(blocks, block{Type: "divider"}, alternativeSection(path))
+	}
+	if shown < len(paths) {
+		blocks = append(blocks, contextBlock(fmt.Sprintf("%d more alternative routes are not shown", len(paths)-shown)))
+	}
+
+	blocks = append(blocks, contextBlock(fmt.Sprintf("Last updated at %s", time.Now().Format("15:04:05"))))
+
+	return s.post(ctx, target.Settings["webhookURL"], title, blocks)
+}
+
+// alternativeSection describes the alternative in a section, leaving out the
+// last segments that do not fit into it.
+func alternativeSection(path models.SegmentPath) block {
+	var description strings.Builder
+	description.WriteString(markdownEscaper.Replace(fmt.Sprintf("*Alternative route with Total Price: %.2f CZK, Seat Changes: %d, Train Changes: %d*\n", path.TotalPrice, path.SeatChanges, path.Transfers)))
+	for i, segment := range path.Segments {
+		// the limit counts the escaped text
+		line := markdownEscaper.Replace(fmt.Sprintf("*%s -> %s* (Departure: %s, Arrival: %s)\n_Free Seats: %d, Price: %.2f CZK, Seat: %s_\n",
+			segment.FromStationName, segment.ToStationName, segment.DepartureTime.Format("15:04"), segment.ArrivalTime.Format("15:04"), segment.FreeSeats, segment.Price, notifier.SeatName(segment)))
+
+		// unless it is the last one, the segment leaves room for the note
+		room := maxSectionText
+		if i < len(path.Segments)-1 {
+			room -= len(moreSegments(len(path.Segments) - i - 1))
+		}
+		if description.Len()+len(line) > room {
+			description.WriteString(moreSegments(len(path.Segments) - i))
+			break
+		}
+		description.WriteString(line)
+	}
+	return block{Type: "section", Text: &text{Type: "mrkdwn", Text: description.String()}}
+}
+
+func moreSegments(n int) string {
+	return fmt.Sprintf("_...and %d more segments_\n", n)
+}
+
+type text struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+type block struct {
+	Type     string `json:"type"`
+	Text     *text  `json:"text,omitempty"`
+	Fields   []text `json:"fields,omitempty"`
+	Elements []text `json:"elements,omitempty"`
+}
+
+var markdownEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+
+// markdown returns mrkdwn text, escaping the characters Slack uses for
+// links and mentions.
+func markdown(value string) text {
+	return text{Type: "mrkdwn", Text: markdownEscaper.Replace(value)}
+}
+
+// headerBlock shortens the value to the limit of a header, which counts
+// characters.
+func headerBlock(value string) block {
+	if runes := []rune(value); len(runes) > maxHeaderText {
+		value = string(runes[:maxHeaderText-1]) + "…"
+	}
+	return block{Type: "header", Text: &text{Type: "plain_text", Text: value}}
+}
+
+func textSection(value string) block {
+	t := markdown(value)
+	return block{Type: "section", Text: &t}
+}
+
+func contextBlock(value string) block {
+	return block{Type: "context", Elements: []text{markdown(value)}}
+}
+
+// post sends the blocks, with fallback being the plain text shown in
+// notifications of Slack clients.
+func (s *SlackService) post(ctx context.Context, webhookURL, fallback string, blocks []block) error {
+	payload := map[string]interface{}{
+		"text":   fallback,
+		"blocks": blocks,
+	}
+
+	jsonPayload, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to marshal JSON payload: %v", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhookURL, bytes.NewReader(jsonPayload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send Slack notification: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		deliveryErr := notifier.NewStatusError("Slack", resp)
+		deliveryErr.Message += ", " + strings.TrimSpace(string(body))
+		return deliveryErr
+	}
+	return nil
+}
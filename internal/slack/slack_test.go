@@ -0,0 +1,102 @@
+package slack
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/bxxf/regiojet-watchdog/internal/models"
+	"go.uber.org/zap"
+)
+
+// newTestWebhook returns the URL of an incoming webhook that collects the
+// blocks of the messages posted to it.
+func newTestWebhook(t *testing.T) (string, *[][]block) {
+	var messages [][]block
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload struct {
+			Blocks []block `json:"blocks"`
+		}
+		json.NewDecoder(r.Body).Decode(&payload)
+		messages = append(messages, payload.Blocks)
+		w.Write([]byte("ok"))
+	}))
+	t.Cleanup(server.Close)
+	return server.URL, &messages
+}
+
+// alternatives returns n alternative routes of the given number of segments
+// between stations of the given name.
+func alternatives(n, segments int, station string) []models.SegmentPath {
+	departure := time.Date(2026, 10, 17, 6, 0, 0, 0, time.UTC)
+	paths := make([]models.SegmentPath, n)
+	for i := range paths {
+		for k := 0; k < segments; k++ {
+			paths[i].Segments = append(paths[i].Segments, models.Segment{
+				FromStationName: fmt.Sprintf("%s %d", station, k),
+				ToStationName:   fmt.Sprintf("%s %d", station, k+1),
+				DepartureTime:   departure.Add(time.Duration(k) * time.Minute),
+				ArrivalTime:     departure.Add(time.Duration(k+1) * time.Minute),
+				FreeSeats:       2,
+				Price:           99,
+			})
+		}
+	}
+	return paths
+}
+
+func TestNotifyAlternativesWithinBlockLimits(t *testing.T) {
+	cases := []struct {
+		paths, segments int
+		station         string
+		notShown        string
+	}{
+		{paths: 2, segments: 3, station: "Ostrava"},
+		{paths: 1, segments: 100, station: "Ostrava", notShown: "more segments"},
+		{paths: 1, segments: 40, station: "A & B <C>", notShown: "more segments"},
+		{paths: 30, segments: 4, station: "Ostrava", notShown: "more alternative routes are not shown"},
+		{paths: 2, segments: 2, station: strings.Repeat("Havířov ", 20)},
+	}
+	for _, c := range cases {
+		webhookURL, messages := newTestWebhook(t)
+		s := NewSlackService(zap.NewNop())
+		if err := s.NotifyAlternatives(context.Background(), models.Watchdog{}, Target(webhookURL), alternatives(c.paths, c.segments, c.station)); err != nil {
+			t.Fatal(err)
+		}
+
+		if len(*messages) != 1 {
+			t.Fatalf("%d alternatives of %d segments: posted %d messages, want 1", c.paths, c.segments, len(*messages))
+		}
+		blocks := (*messages)[0]
+		if len(blocks) > maxBlocks {
+			t.Errorf("%d alternatives of %d segments: posted %d blocks, more than %d", c.paths, c.segments, len(blocks), maxBlocks)
+		}
+		var all strings.Builder
+		for _, b := range blocks {
+			switch {
+			case b.Type == "header" && utf8.RuneCountInString(b.Text.Text) > maxHeaderText:
+				t.Errorf("%d alternatives of %d segments: posted a header of %d characters, more than %d", c.paths, c.segments, utf8.RuneCountInString(b.Text.Text), maxHeaderText)
+			case b.Type == "section" && len(b.Text.Text) > maxSectionText:
+				t.Errorf("%d alternatives of %d segments: posted a section of %d bytes, more than %d", c.paths, c.segments, len(b.Text.Text), maxSectionText)
+			}
+			if b.Text != nil {
+				all.WriteString(b.Text.Text)
+			}
+			for _, element := range b.Elements {
+				all.WriteString(element.Text)
+			}
+		}
+		if c.notShown != "" && !strings.Contains(all.String(), c.notShown) {
+			t.Errorf("%d alternatives of %d segments: the message does not note that %s", c.paths, c.segments, c.notShown)
+		}
+		if !strings.Contains(all.String(), "Last updated at") {
+			t.Errorf("%d alternatives of %d segments: the message lost its footer", c.paths, c.segments)
+		}
+	}
+}
@@ -0,0 +1,226 @@
+package telegram
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/bxxf/regiojet-watchdog/internal/config"
+	"github.com/bxxf/regiojet-watchdog/internal/models"
+	"github.com/bxxf/regiojet-watchdog/internal/notifier"
+	"go.uber.org/zap"
+)
+
+const (
+	targetType = "telegram"
+
+	// maxMessageLength is the limit of the Bot API for the text of a message.
+	maxMessageLength = 4096
+)
+
+// TelegramService sends notifications as HTML formatted messages through
+// the sendMessage method of the Telegram Bot API.
+type TelegramService struct {
+	logger   *zap.Logger
+	client   *http.Client
+	botToken string
+	apiURL   string
+}
+
+func NewTelegramService(logger *zap.Logger, config config.Config) *TelegramService {
+	return &TelegramService{
+		logger:   logger,
+		client:   &http.Client{},
+		botToken: config.TelegramBotToken,
+		apiURL:   strings.TrimSuffix(config.TelegramAPIURL, "/"),
+	}
+}
+
+// Target returns a notification target that sends messages to the chat.
+func Target(chatID string) models.NotificationTarget {
+	return models.NotificationTarget{
+		Type:     targetType,
+		Settings: map[string]string{"chatID": chatID},
+	}
+}
+
+func (s *TelegramService) Type() string {
+	return targetType
+}
+
+func (s *TelegramService) Validate(target models.NotificationTarget) error {
+	if s.botToken == "" {
+		return errors.New("telegram notifications are not configured, TELEGRAM_BOT_TOKEN is not set")
+	}
+	if target.Settings["chatID"] == "" {
+		return errors.New("telegram target requires a chatID setting")
+	}
+	return nil
+}
+
+func (s *TelegramService) NotifyFreeSeats(ctx context.Context, watchdog models.Watchdog, target models.NotificationTarget, freeSeats models.FreeSeatsResponse, routeDetails models.RouteDetails) error {
+	if routeDetails.FreeSeatsCount == 0 {
+		return nil
+	}
+
+	var text strings.Builder
+	fmt.Fprintf(&text, "<b>Tickets available (%s -&gt; %s) - %s -&gt; %s [%s]</b>\n",
+		html.EscapeString(routeDetails.DepartureCityName),
+		html.EscapeString(routeDetails.ArrivalCityName),
+		notifier.Clock(routeDetails.DepartureTime),
+		notifier.Clock(routeDetails.ArrivalTime),
+		notifier.Date(routeDetails.DepartureTime),
+	)
+	fmt.Fprintf(&text, "Travel Time: %s, Free seats count: %d\n\n", html.EscapeString(routeDetails.TravelTime), routeDetails.FreeSeatsCount)
+
+	for _, vehicle := range notifier.CountFreeSeats(freeSeats) {
+		fmt.Fprintf(&text, "Vehicle Number: <b>%d</b> (%s) - Number of Free Seats: <b>%d</b>\n",
+			vehicle.VehicleNumber, html.EscapeString(vehicle.SeatClass), vehicle.FreeSeats)
+	}
+
+	fmt.Fprintf(&text, "\n<i>Price From: %dCZK, Price To: %dCZK</i>", int(routeDetails.PriceFrom), int(routeDetails.PriceTo))
+
+	return s.sendMessage(ctx, target.Settings["chatID"], text.String())
+}
+
+func (s *TelegramService) NotifySoldOut(ctx context.Context, watchdog models.Watchdog, target models.NotificationTarget, routeDetails models.RouteDetails) error {
+	text := fmt.Sprintf("<b>Sold out (%s -&gt; %s) - %s -&gt; %s [%s]</b>\nThere are no free seats left, you will be notified when some appear again.",
+		html.EscapeString(routeDetails.DepartureCityName),
+		html.EscapeString(routeDetails.ArrivalCityName),
+		notifier.Clock(routeDetails.DepartureTime),
+		notifier.Clock(routeDetails.ArrivalTime),
+		notifier.Date(routeDetails.DepartureTime),
+	)
+
+	return s.sendMessage(ctx, target.Settings["chatID"], text)
+}
+
+// NotifyAlternatives sends the alternatives as one message, so a retry never
+// repeats part of them. Alternatives that do not fit into the message are
+// left out, and so are the last segments of an alternative too long for it.
+func (s *TelegramService) NotifyAlternatives(ctx context.Context, watchdog models.Watchdog, target models.NotificationTarget, paths []models.SegmentPath) error {
+	if len(paths) == 0 {
+		return nil
+	}
+
+	from, to, date := notifier.Journey(paths)
+	message := fmt.Sprintf("<b>Alternative routes %s -&gt; %s (%s)</b>\n",
+		html.EscapeString(from),
+		html.EscapeString(to),
+		date,
+	)
+	footer := fmt.Sprintf("\n<i>Last updated at %s</i>", time.Now().Format("15:04:05"))
+
+	// The limit counts characters of the text without the HTML tags, so
+	// counting bytes with the tags stays within it. Room is left for the
+	// note on the alternatives left out.
+	room := maxMessageLength - len(message) - len(footer) - len(moreAlternatives(len(paths)))
+	shown := 0
+	for _, path := range paths {
+		block := fmt.Sprintf("\n<u>Alternative route with Total Price: %.2f CZK, Seat Changes: %d, Train Changes: %d</u>\n", path.TotalPrice, path.SeatChanges, path.Transfers)
+		block += segmentsDescription(path.Segments, room-len(block))
+		if len(block) > room {
+			break
+		}
+
+		message += block
+		room -= len(block)
+		shown++
+	}
+	if shown < len(paths) {
+		message += moreAlternatives(len(paths) - shown)
+	}
+	message += footer
+
+	return s.sendMessage(ctx, target.Settings["chatID"], message)
+}
+
+func moreAlternatives(n int) string {
+	return fmt.Sprintf("\n<i>%d more alternative routes are not shown</i>\n", n)
+}
+
+// segmentsDescription describes the segments within limit bytes, ending with
+// a note on the segments that do not fit.
+func segmentsDescription(segments []models.Segment, limit int) string {
+	var description strings.Builder
+	for i, segment := range segments {
+		line := fmt.Sprintf("<b>%s -&gt; %s</b> (Departure: %s, Arrival: %s)\n<i>Free Seats: %d, Price: %.2f CZK, Seat: %s</i>\n",
+			html.EscapeString(segment.FromStationName),
+			html.EscapeString(segment.ToStationName),
+			segment.DepartureTime.Format("15:04"),
+			segment.ArrivalTime.Format("15:04"),
+			segment.FreeSeats,
+			segment.Price,
+			html.EscapeString(notifier.SeatName(segment)),
+		)
+
+		// unless it is the last one, the segment leaves room for the note
+		room := limit
+		if i < len(segments)-1 {
+			room -= len(moreSegments(len(segments) - i - 1))
+		}
+		if description.Len()+len(line) > room {
+			description.WriteString(moreSegments(len(segments) - i))
+			break
+		}
+		description.WriteString(line)
+	}
+	return description.String()
+}
+
+func moreSegments(n int) string {
+	return fmt.Sprintf("<i>...and %d more segments</i>\n", n)
+}
+
+func (s *TelegramService) sendMessage(ctx context.Context, chatID, text string) error {
+	payload := map[string]interface{}{
+		"chat_id":                  chatID,
+		"text":                     text,
+		"parse_mode":               "HTML",
+		"disable_web_page_preview": true,
+	}
+
+	jsonPayload, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to marshal JSON payload: %v", err)
+	}
+
+	url := fmt.Sprintf("%s/bot%s/sendMessage", s.apiURL, s.botToken)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(jsonPayload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		// the URL contains the bot token, so the error is not wrapped
+		return errors.New("failed to send Telegram message")
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		OK          bool   `json:"ok"`
+		Description string `json:"description"`
+		Parameters  struct {
+			RetryAfter int `json:"retry_after"`
+		} `json:"parameters"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return notifier.NewStatusError("Telegram", resp)
+	}
+	if !result.OK {
+		return &notifier.DeliveryError{
+			StatusCode: resp.StatusCode,
+			RetryAfter: time.Duration(result.Parameters.RetryAfter) * time.Second,
+			Message:    fmt.Sprintf("failed to send Telegram message: %s", result.Description),
+		}
+	}
+	return nil
+}
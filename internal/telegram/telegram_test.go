@@ -0,0 +1,84 @@
+package telegram
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bxxf/regiojet-watchdog/internal/config"
+	"github.com/bxxf/regiojet-watchdog/internal/models"
+	"go.uber.org/zap"
+)
+
+// newTestService returns a service of a Bot API that collects the texts of
+// the messages sent to it.
+func newTestService(t *testing.T) (*TelegramService, *[]string) {
+	var texts []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload struct {
+			Text string `json:"text"`
+		}
+		json.NewDecoder(r.Body).Decode(&payload)
+		texts = append(texts, payload.Text)
+		w.Write([]byte(`{"ok":true}`))
+	}))
+	t.Cleanup(server.Close)
+
+	return NewTelegramService(zap.NewNop(), config.Config{TelegramBotToken: "token", TelegramAPIURL: server.URL}), &texts
+}
+
+// alternatives returns n alternative routes of the given number of segments.
+func alternatives(n, segments int) []models.SegmentPath {
+	departure := time.Date(2026, 10, 17, 6, 0, 0, 0, time.UTC)
+	paths := make([]models.SegmentPath, n)
+	for i := range paths {
+		for k := 0; k < segments; k++ {
+			paths[i].Segments = append(paths[i].Segments, models.Segment{
+				FromStationName: fmt.Sprintf("Station & %d", k),
+				ToStationName:   fmt.Sprintf("Station & %d", k+1),
+				DepartureTime:   departure.Add(time.Duration(k) * time.Minute),
+				ArrivalTime:     departure.Add(time.Duration(k+1) * time.Minute),
+				FreeSeats:       2,
+				Price:           99,
+			})
+		}
+	}
+	return paths
+}
+
+func TestNotifyAlternativesSendsOneMessage(t *testing.T) {
+	cases := []struct {
+		paths, segments int
+		notShown        string
+	}{
+		{paths: 2, segments: 3},
+		{paths: 30, segments: 4, notShown: "more alternative routes are not shown"},
+		{paths: 1, segments: 100, notShown: "more segments"},
+		{paths: 5, segments: 100, notShown: "more alternative routes are not shown"},
+	}
+	for _, c := range cases {
+		s, texts := newTestService(t)
+		if err := s.NotifyAlternatives(context.Background(), models.Watchdog{}, Target("1"), alternatives(c.paths, c.segments)); err != nil {
+			t.Fatal(err)
+		}
+
+		if len(*texts) != 1 {
+			t.Fatalf("%d alternatives of %d segments: sent %d messages, want 1", c.paths, c.segments, len(*texts))
+		}
+		text := (*texts)[0]
+		if len(text) > maxMessageLength {
+			t.Errorf("%d alternatives of %d segments: sent %d bytes, more than %d", c.paths, c.segments, len(text), maxMessageLength)
+		}
+		if c.notShown != "" && !strings.Contains(text, c.notShown) {
+			t.Errorf("%d alternatives of %d segments: the message does not note that %s", c.paths, c.segments, c.notShown)
+		}
+		if !strings.Contains(text, "Last updated at") {
+			t.Errorf("%d alternatives of %d segments: the message lost its footer", c.paths, c.segments)
+		}
+	}
+}
@@ -0,0 +1,173 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/bxxf/regiojet-watchdog/internal/models"
+	"github.com/bxxf/regiojet-watchdog/internal/notifier"
+	"go.uber.org/zap"
+)
+
+const (
+	targetType = "webhook"
+
+	// EventVersion is bumped whenever a field of Event changes incompatibly.
+	// Version 2 replaced the alternatives with typed segment paths.
+	EventVersion = 2
+
+	SignatureHeader = "X-Watchdog-Signature"
+	EventHeader     = "X-Watchdog-Event"
+
+	EventFreeSeats    = "free_seats"
+	EventSoldOut      = "sold_out"
+	EventAlternatives = "alternatives"
+
+	minSecretLength = 16
+)
+
+// Event is the JSON body posted to webhook targets.
+type Event struct {
+	Version      int                     `json:"version"`
+	Event        string                  `json:"event"`
+	WatchdogID   string                  `json:"watchdogId"`
+	SentAt       time.Time               `json:"sentAt"`
+	Route        Route                   `json:"route"`
+	FreeSeats    []notifier.VehicleSeats `json:"freeSeats,omitempty"`
+	Alternatives []models.SegmentPath    `json:"alternatives,omitempty"`
+}
+
+type Route struct {
+	RouteID           string  `json:"routeId"`
+	StationFromID     string  `json:"stationFromId"`
+	StationToID       string  `json:"stationToId"`
+	DepartureCityName string  `json:"departureCityName,omitempty"`
+	ArrivalCityName   string  `json:"arrivalCityName,omitempty"`
+	DepartureTime     string  `json:"departureTime,omitempty"`
+	ArrivalTime       string  `json:"arrivalTime,omitempty"`
+	TravelTime        string  `json:"travelTime,omitempty"`
+	FreeSeatsCount    int     `json:"freeSeatsCount,omitempty"`
+	PriceFrom         float64 `json:"priceFrom,omitempty"`
+	PriceTo           float64 `json:"priceTo,omitempty"`
+	Currency          string  `json:"currency"`
+}
+
+// WebhookService posts signed JSON events to arbitrary URLs. The body is
+// signed with HMAC-SHA256 using the secret of the target and the hex digest
+// is sent as "sha256=<digest>" in the X-Watchdog-Signature header.
+type WebhookService struct {
+	logger *zap.Logger
+	client *http.Client
+}
+
+func NewWebhookService(logger *zap.Logger) *WebhookService {
+	return &WebhookService{
+		logger: logger,
+		client: &http.Client{},
+	}
+}
+
+func (s *WebhookService) Type() string {
+	return targetType
+}
+
+// SecretSettings hides the secret the events are signed with.
+func (s *WebhookService) SecretSettings() []string {
+	return []string{"secret"}
+}
+
+func (s *WebhookService) Validate(target models.NotificationTarget) error {
+	u, err := url.Parse(target.Settings["url"])
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errors.New("webhook target requires an http(s) url setting")
+	}
+	if len(target.Settings["secret"]) < minSecretLength {
+		return fmt.Errorf("webhook target requires a secret setting of at least %d characters", minSecretLength)
+	}
+	return nil
+}
+
+func (s *WebhookService) NotifyFreeSeats(ctx context.Context, watchdog models.Watchdog, target models.NotificationTarget, freeSeats models.FreeSeatsResponse, routeDetails models.RouteDetails) error {
+	event := newEvent(EventFreeSeats, watchdog, &routeDetails)
+	event.FreeSeats = notifier.CountFreeSeats(freeSeats)
+	return s.post(ctx, target, event)
+}
+
+func (s *WebhookService) NotifySoldOut(ctx context.Context, watchdog models.Watchdog, target models.NotificationTarget, routeDetails models.RouteDetails) error {
+	return s.post(ctx, target, newEvent(EventSoldOut, watchdog, &routeDetails))
+}
+
+func (s *WebhookService) NotifyAlternatives(ctx context.Context, watchdog models.Watchdog, target models.NotificationTarget, paths []models.SegmentPath) error {
+	event := newEvent(EventAlternatives, watchdog, nil)
+	event.Alternatives = paths
+	return s.post(ctx, target, event)
+}
+
+func newEvent(eventType string, watchdog models.Watchdog, routeDetails *models.RouteDetails) Event {
+	route := Route{
+		RouteID:       watchdog.RouteID,
+		StationFromID: watchdog.StationFromID,
+		StationToID:   watchdog.StationToID,
+		Currency:      "CZK",
+	}
+	if routeDetails != nil {
+		route.DepartureCityName = routeDetails.DepartureCityName
+		route.ArrivalCityName = routeDetails.ArrivalCityName
+		route.DepartureTime = routeDetails.DepartureTime
+		route.ArrivalTime = routeDetails.ArrivalTime
+		route.TravelTime = routeDetails.TravelTime
+		route.FreeSeatsCount = routeDetails.FreeSeatsCount
+		route.PriceFrom = routeDetails.PriceFrom
+		route.PriceTo = routeDetails.PriceTo
+	}
+
+	return Event{
+		Version:    EventVersion,
+		Event:      eventType,
+		WatchdogID: watchdog.ID,
+		SentAt:     time.Now().UTC(),
+		Route:      route,
+	}
+}
+
+// Sign returns the value of the X-Watchdog-Signature header for body.
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func (s *WebhookService) post(ctx context.Context, target models.NotificationTarget, event Event) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to marshal webhook event: %v", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target.Settings["url"], bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(EventHeader, event.Event)
+	req.Header.Set(SignatureHeader, Sign(target.Settings["secret"], body))
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send webhook: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return notifier.NewStatusError("webhook", resp)
+	}
+	return nil
+}
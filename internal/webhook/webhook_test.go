@@ -0,0 +1,118 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/bxxf/regiojet-watchdog/internal/models"
+	"go.uber.org/zap"
+)
+
+const testSecret = "at-least-16-characters"
+
+// eventBody is the body of an alternatives event of version 2, without the
+// alternatives.
+const eventBody = `{"version":2,"event":"alternatives","watchdogId":"a","sentAt":"2026-10-17T06:00:00Z","route":{"routeId":"101020261017","stationFromId":"372825000","stationToId":"508808000","currency":"CZK"}}`
+
+func TestSign(t *testing.T) {
+	cases := []struct {
+		name, secret, body, want string
+	}{
+		{
+			name:   "known vector",
+			secret: "key",
+			body:   "The quick brown fox jumps over the lazy dog",
+			want:   "sha256=f7bc83f430538424b13298e6aa6fb143ef4d59a14946175997479dbc2d1a3cd8",
+		},
+		{
+			name:   "event",
+			secret: testSecret,
+			body:   eventBody,
+			want:   "sha256=ff1d5ed0c89d63eaa216cd0c80e6ebff9bbe1d107418b3c178733db54ea7db03",
+		},
+	}
+	for _, c := range cases {
+		if got := Sign(c.secret, []byte(c.body)); got != c.want {
+			t.Errorf("%s: got %s, want %s", c.name, got, c.want)
+		}
+	}
+}
+
+func TestEventBody(t *testing.T) {
+	event := newEvent(EventAlternatives, models.Watchdog{
+		ID:            "a",
+		RouteID:       "101020261017",
+		StationFromID: "372825000",
+		StationToID:   "508808000",
+	}, nil)
+	event.SentAt = time.Date(2026, 10, 17, 6, 0, 0, 0, time.UTC)
+
+	body, err := json.Marshal(event)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the body signed in TestSign, which changes with EventVersion
+	if string(body) != eventBody {
+		t.Errorf("got body %s, want %s", body, eventBody)
+	}
+}
+
+func TestPostSignsBody(t *testing.T) {
+	var body []byte
+	var signature, eventType string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ = io.ReadAll(r.Body)
+		signature = r.Header.Get(SignatureHeader)
+		eventType = r.Header.Get(EventHeader)
+	}))
+	defer server.Close()
+
+	s := NewWebhookService(zap.NewNop())
+	target := models.NotificationTarget{Type: targetType, Settings: map[string]string{"url": server.URL, "secret": testSecret}}
+	if err := s.NotifyAlternatives(context.Background(), models.Watchdog{ID: "a"}, target, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if eventType != EventAlternatives {
+		t.Errorf("got event %q, want %q", eventType, EventAlternatives)
+	}
+	if want := Sign(testSecret, body); signature != want {
+		t.Errorf("got signature %s, want %s", signature, want)
+	}
+	var event Event
+	if err := json.Unmarshal(body, &event); err != nil {
+		t.Fatal(err)
+	}
+	if event.Version != EventVersion {
+		t.Errorf("got version %d, want %d", event.Version, EventVersion)
+	}
+}
+
+func TestSoldOutEvent(t *testing.T) {
+	var eventType string
+	var event Event
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		eventType = r.Header.Get(EventHeader)
+		json.NewDecoder(r.Body).Decode(&event)
+	}))
+	defer server.Close()
+
+	s := NewWebhookService(zap.NewNop())
+	target := models.NotificationTarget{Type: targetType, Settings: map[string]string{"url": server.URL, "secret": testSecret}}
+	routeDetails := models.RouteDetails{DepartureCityName: "Praha", ArrivalCityName: "Havířov"}
+	if err := s.NotifySoldOut(context.Background(), models.Watchdog{ID: "a"}, target, routeDetails); err != nil {
+		t.Fatal(err)
+	}
+
+	if eventType != EventSoldOut || event.Event != EventSoldOut {
+		t.Errorf("got event %q with header %q, want %q", event.Event, eventType, EventSoldOut)
+	}
+	if event.Route.DepartureCityName != "Praha" || event.Route.FreeSeatsCount != 0 || len(event.FreeSeats) != 0 {
+		t.Errorf("got route %+v with free seats %v", event.Route, event.FreeSeats)
+	}
+}
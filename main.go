@@ -7,9 +7,14 @@ import (
 	"github.com/bxxf/regiojet-watchdog/internal/constants"
 	"github.com/bxxf/regiojet-watchdog/internal/database"
 	"github.com/bxxf/regiojet-watchdog/internal/discord"
+	"github.com/bxxf/regiojet-watchdog/internal/email"
 	"github.com/bxxf/regiojet-watchdog/internal/logger"
+	"github.com/bxxf/regiojet-watchdog/internal/notifier"
 	"github.com/bxxf/regiojet-watchdog/internal/segmentation"
 	"github.com/bxxf/regiojet-watchdog/internal/server"
+	"github.com/bxxf/regiojet-watchdog/internal/slack"
+	"github.com/bxxf/regiojet-watchdog/internal/telegram"
+	"github.com/bxxf/regiojet-watchdog/internal/webhook"
 	"go.uber.org/fx"
 )
 
@@ -23,10 +28,16 @@ func main() {
 			checker.NewChecker,
 			segmentation.NewSegmentationService,
 			server.NewServer,
-			discord.NewDiscordService,
-			database.NewDatabaseClient,
+			notifier.NewNotificationService,
+			notifier.AsNotifier(discord.NewDiscordService),
+			notifier.AsNotifier(webhook.NewWebhookService),
+			notifier.AsNotifier(telegram.NewTelegramService),
+			notifier.AsNotifier(email.NewEmailService),
+			notifier.AsNotifier(slack.NewSlackService),
+			notifier.NewSender,
+			fx.Annotate(database.NewStore, fx.As(new(database.WatchdogStore)), fx.As(new(database.NotificationStore))),
 		),
-		fx.Invoke(constants.RegisterConstantsHooks, server.RegisterServerHooks, checker.RegisterCheckerHooks),
+		fx.Invoke(database.RegisterDatabaseHooks, constants.RegisterConstantsHooks, client.RegisterClientHooks, server.RegisterServerHooks, checker.RegisterCheckerHooks, notifier.RegisterSenderHooks),
 	)
 
 	app.Run()